@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"langapp-backend/auth"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type BlockUserRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// ListBlocks returns the users the authenticated user has blocked
+func (api *APIService) ListBlocks(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+
+	blocks, err := api.blockService.ListBlocks(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list blocks of user %s: %v", userID, err)
+		http.Error(w, "Failed to list blocks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocks)
+}
+
+// BlockUser stops the authenticated user from ever being matched with another user, in
+// either direction
+func (api *APIService) BlockUser(w http.ResponseWriter, r *http.Request) {
+	var req BlockUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := auth.UserIDFromContext(r.Context())
+	blockedID := strings.TrimSpace(req.UserID)
+	if blockedID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if blockedID == userID {
+		http.Error(w, "Cannot block yourself", http.StatusBadRequest)
+		return
+	}
+
+	blocked, err := api.usersRepository.GetUserByID(r.Context(), blockedID)
+	if err != nil {
+		log.Printf("Failed to get user %s: %v", blockedID, err)
+		http.Error(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+	if blocked == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := api.usersRepository.EnsureUser(r.Context(), userID); err != nil {
+		log.Printf("Failed to ensure user %s: %v", userID, err)
+		http.Error(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	if err := api.blockService.Block(r.Context(), userID, blockedID); err != nil {
+		log.Printf("Failed to block user %s for %s: %v", blockedID, userID, err)
+		http.Error(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnblockUser lifts a block set by the authenticated user
+func (api *APIService) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	blockedID := chi.URLParam(r, "user_id")
+
+	removed, err := api.blockService.Unblock(r.Context(), userID, blockedID)
+	if err != nil {
+		log.Printf("Failed to unblock user %s for %s: %v", blockedID, userID, err)
+		http.Error(w, "Failed to unblock user", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "User is not blocked", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
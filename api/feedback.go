@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"langapp-backend/auth"
+	"langapp-backend/feedback"
+	"langapp-backend/session"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const maxFeedbackCommentLength = 1000
+
+type SubmitFeedbackRequest struct {
+	Rating  int      `json:"rating"`
+	Tags    []string `json:"tags"`
+	Comment string   `json:"comment"`
+}
+
+// SubmitFeedback lets a participant of a completed session rate their partner, once per session
+func (api *APIService) SubmitFeedback(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(chi.URLParam(r, "session_id"))
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SubmitFeedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ok, msg := validateSubmitFeedbackRequest(&req)
+	if !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	sess, err := api.sessionRepository.GetSessionByID(r.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, session.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get session %s: %v", sessionID, err)
+		http.Error(w, "Failed to submit feedback", http.StatusInternalServerError)
+		return
+	}
+
+	userID := auth.UserIDFromContext(r.Context())
+	partnerID, ok := sess.PartnerID(userID)
+	if !ok {
+		http.Error(w, "Not a participant of this session", http.StatusForbidden)
+		return
+	}
+
+	if sess.Status != session.SessionCompleted {
+		http.Error(w, "Feedback can only be given for completed sessions", http.StatusConflict)
+		return
+	}
+
+	fb := &feedback.Feedback{
+		SessionID:  sessionID,
+		FromUserID: userID,
+		ToUserID:   partnerID,
+		Rating:     req.Rating,
+		Tags:       req.Tags,
+		Comment:    req.Comment,
+	}
+
+	if err := api.feedbackRepository.CreateFeedback(r.Context(), fb); err != nil {
+		if errors.Is(err, feedback.ErrFeedbackExists) {
+			http.Error(w, "Feedback already submitted for this session", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to store feedback from %s for session %s: %v", userID, sessionID, err)
+		http.Error(w, "Failed to submit feedback", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(fb)
+}
+
+// GetUserReputation returns the aggregated ratings and tags a user has received
+func (api *APIService) GetUserReputation(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+
+	user, err := api.usersRepository.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get user %s: %v", userID, err)
+		http.Error(w, "Failed to get reputation", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	reputation, err := api.feedbackRepository.GetReputation(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get reputation of user %s: %v", userID, err)
+		http.Error(w, "Failed to get reputation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reputation)
+}
+
+// validateSubmitFeedbackRequest validates req, normalizing its tags to lower case without duplicates
+func validateSubmitFeedbackRequest(req *SubmitFeedbackRequest) (bool, string) {
+	if req.Rating < feedback.MinRating || req.Rating > feedback.MaxRating {
+		return false, fmt.Sprintf("Invalid rating: must be between %d and %d", feedback.MinRating, feedback.MaxRating)
+	}
+
+	tags := []string{}
+	for _, tag := range req.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !feedback.IsValidTag(tag) {
+			return false, fmt.Sprintf("Invalid tag '%s': must be one of %s", tag, strings.Join(feedback.Tags, ", "))
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	req.Tags = tags
+
+	req.Comment = strings.TrimSpace(req.Comment)
+	if len([]rune(req.Comment)) > maxFeedbackCommentLength {
+		return false, fmt.Sprintf("comment must be at most %d characters", maxFeedbackCommentLength)
+	}
+
+	return true, ""
+}
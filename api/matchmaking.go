@@ -3,22 +3,40 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
-	"strings"
+	"slices"
 	"time"
+
+	"langapp-backend/auth"
+	"langapp-backend/languages"
+	"langapp-backend/matchmaking"
+	"langapp-backend/users"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// StartMatchmakingRequest starts matchmaking for the authenticated user, who is queued for all
+// of the given languages at once. native_language and practice_language are shorthands for
+// single-element lists, and level gives the level in practice_language. Omitted languages
+// default to the languages of the user's profile, and omitted levels to the profile's proficiency.
 type StartMatchmakingRequest struct {
-	UserID           string `json:"user_id"`
-	NativeLanguage   string `json:"native_language"`
-	PracticeLanguage string `json:"practice_language"`
+	NativeLanguage    string                     `json:"native_language,omitempty"`
+	PracticeLanguage  string                     `json:"practice_language,omitempty"`
+	NativeLanguages   []string                   `json:"native_languages,omitempty"`
+	PracticeLanguages []string                   `json:"practice_languages,omitempty"`
+	MatchMode         matchmaking.MatchMode      `json:"match_mode,omitempty"`
+	Level             languages.Level            `json:"level,omitempty"`
+	Levels            map[string]languages.Level `json:"levels,omitempty"`
+	PartnerLevelMin   languages.Level            `json:"partner_level_min,omitempty"`
+	PartnerLevelMax   languages.Level            `json:"partner_level_max,omitempty"`
 }
 
-type CancelMatchmakingRequest struct {
-	UserID           string `json:"user_id"`
-	PracticeLanguage string `json:"practice_language"`
-}
+// maxQueueLanguages limits how many native or practice languages a user can queue for at once
+const maxQueueLanguages = 5
 
 type StartMatchmakingResponse struct {
 	Message      string    `json:"message"`
@@ -30,25 +48,78 @@ type CancelMatchmakingResponse struct {
 	Message string `json:"message"`
 }
 
+// StartMatchmaking puts the authenticated user in the queues of their practice languages. The
+// body is optional: languages and levels it leaves out are taken from the user's profile.
 func (api *APIService) StartMatchmaking(w http.ResponseWriter, r *http.Request) {
 	var req StartMatchmakingRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	ok, msg := api.validateStartMatchmakingRequest(r.Context(), req)
+	userID := auth.UserIDFromContext(r.Context())
+
+	suspension, err := api.moderationRepository.GetActiveSuspension(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to check suspension of user %s: %v", userID, err)
+		http.Error(w, "Failed to join queue", http.StatusInternalServerError)
+		return
+	}
+	if suspension != nil {
+		http.Error(w, suspension.Message(), http.StatusForbidden)
+		return
+	}
+
+	profile, err := api.usersRepository.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to load profile of user %s: %v", userID, err)
+		http.Error(w, "Failed to load user profile", http.StatusInternalServerError)
+		return
+	}
+	applyProfileLanguages(profile, &req)
+
+	ok, msg := api.validateStartMatchmakingRequest(r.Context(), &req)
 	if !ok {
 		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
 
-	userID := req.UserID
-	nativeLanguage := req.NativeLanguage
-	practiceLanguage := req.PracticeLanguage
+	if profile != nil {
+		for _, language := range req.PracticeLanguages {
+			if _, ok := req.Levels[language]; ok {
+				continue
+			}
+			if level, ok := profile.LearningLevel(language); ok {
+				req.Levels[language] = level
+			}
+		}
+	}
+
+	// Sessions reference users, so make sure a user row exists before the user can be matched
+	if err := api.usersRepository.EnsureUser(r.Context(), userID); err != nil {
+		log.Printf("Failed to ensure user %s exists: %v", userID, err)
+		http.Error(w, "Failed to join queue", http.StatusInternalServerError)
+		return
+	}
+
+	preferences := matchmaking.MatchPreferences{
+		MatchMode:       req.MatchMode,
+		Levels:          req.Levels,
+		PartnerLevelMin: req.PartnerLevelMin,
+		PartnerLevelMax: req.PartnerLevelMax,
+	}
 
-	entry, err := api.matchmakingService.InitiateMatchmaking(r.Context(), userID, nativeLanguage, practiceLanguage)
+	entry, err := api.matchmakingService.InitiateMatchmaking(r.Context(), userID, req.NativeLanguages, req.PracticeLanguages, preferences)
 	if err != nil {
+		if errors.Is(err, matchmaking.ErrDraining) {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Server is restarting, please retry", http.StatusServiceUnavailable)
+			return
+		}
+		if errors.Is(err, matchmaking.ErrProposalPending) {
+			http.Error(w, "A proposed match is waiting for your answer; accept or decline it first", http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to join queue", http.StatusInternalServerError)
 		return
 	}
@@ -56,7 +127,7 @@ func (api *APIService) StartMatchmaking(w http.ResponseWriter, r *http.Request)
 	response := StartMatchmakingResponse{
 		Message:      "Successfully joined matchmaking queue. Connect to the WebSocket URL to receive match notifications.",
 		QueuedAt:     entry.Timestamp,
-		WebSocketURL: api.getWebSocketURL(req.UserID, r),
+		WebSocketURL: api.getWebSocketURL(r),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -64,48 +135,136 @@ func (api *APIService) StartMatchmaking(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
-func (api *APIService) validateStartMatchmakingRequest(ctx context.Context, req StartMatchmakingRequest) (bool, string) {
-	if req.UserID == "" || req.NativeLanguage == "" || req.PracticeLanguage == "" {
-		return false, "Missing required fields: user_id, native_language, practice_language"
+// applyProfileLanguages fills in the languages missing from req from the user's profile, if any
+func applyProfileLanguages(profile *users.User, req *StartMatchmakingRequest) {
+	if profile == nil {
+		return
 	}
 
-	if strings.EqualFold(req.NativeLanguage, req.PracticeLanguage) {
-		return false, "Native language and practice language cannot be the same"
+	if req.NativeLanguage == "" && len(req.NativeLanguages) == 0 {
+		req.NativeLanguages = append(req.NativeLanguages, profile.NativeLanguages...)
+	}
+	if req.PracticeLanguage == "" && len(req.PracticeLanguages) == 0 {
+		for _, learning := range profile.LearningLanguages {
+			req.PracticeLanguages = append(req.PracticeLanguages, learning.Language)
+		}
 	}
+}
 
-	nativeLanguage, err := api.languagesRepository.GetLanguageByName(ctx, req.NativeLanguage)
-	if err != nil {
-		return false, "Error validating native language"
+// validateStartMatchmakingRequest validates req and normalizes it: the single-language fields
+// are merged into the lists, languages are replaced by their canonical names, levels are
+// keyed by canonical practice language and upper case
+func (api *APIService) validateStartMatchmakingRequest(ctx context.Context, req *StartMatchmakingRequest) (bool, string) {
+	if req.MatchMode != "" && !req.MatchMode.IsValid() {
+		return false, "Invalid match_mode: must be 'reciprocal' or 'tutor'"
+	}
+
+	if req.NativeLanguage != "" {
+		req.NativeLanguages = append([]string{req.NativeLanguage}, req.NativeLanguages...)
+	}
+	if req.PracticeLanguage != "" {
+		req.PracticeLanguages = append([]string{req.PracticeLanguage}, req.PracticeLanguages...)
 	}
-	if nativeLanguage == nil {
-		return false, "Invalid native language"
+	if len(req.NativeLanguages) == 0 || len(req.PracticeLanguages) == 0 {
+		return false, "Missing required fields: native_languages, practice_languages (set them in the request or in your profile)"
 	}
 
-	practiceLanguage, err := api.languagesRepository.GetLanguageByName(ctx, req.PracticeLanguage)
-	if err != nil {
-		return false, "Error validating practice language"
+	nativeLanguages, ok, msg := api.resolveLanguages(ctx, req.NativeLanguages, "native")
+	if !ok {
+		return false, msg
+	}
+	practiceLanguages, ok, msg := api.resolveLanguages(ctx, req.PracticeLanguages, "practice")
+	if !ok {
+		return false, msg
+	}
+	for _, language := range practiceLanguages {
+		if slices.Contains(nativeLanguages, language) {
+			return false, fmt.Sprintf("%s cannot be both a native and a practice language", language)
+		}
+	}
+
+	levels := make(map[string]languages.Level)
+	if req.Level != "" {
+		if req.PracticeLanguage == "" {
+			return false, "level requires practice_language; use levels for practice_languages"
+		}
+		levels[req.PracticeLanguage] = req.Level
+	}
+	for name, level := range req.Levels {
+		levels[name] = level
+	}
+
+	req.NativeLanguages = nativeLanguages
+	req.PracticeLanguages = practiceLanguages
+	req.Levels = make(map[string]languages.Level)
+	for name, level := range levels {
+		language, err := api.languagesRepository.GetLanguageByName(ctx, name)
+		if err != nil {
+			return false, "Error validating levels"
+		}
+		if language == nil || !slices.Contains(practiceLanguages, language.Name) {
+			return false, fmt.Sprintf("Invalid levels: %s is not one of your practice languages", name)
+		}
+
+		parsed, ok := languages.ParseLevel(string(level))
+		if !ok {
+			return false, fmt.Sprintf("Invalid level for %s: must be one of A1, A2, B1, B2, C1, C2", language.Name)
+		}
+		req.Levels[language.Name] = parsed
+	}
+
+	bounds := []struct {
+		field string
+		level *languages.Level
+	}{
+		{"partner_level_min", &req.PartnerLevelMin},
+		{"partner_level_max", &req.PartnerLevelMax},
+	}
+	for _, b := range bounds {
+		if *b.level == "" {
+			continue
+		}
+		level, ok := languages.ParseLevel(string(*b.level))
+		if !ok {
+			return false, fmt.Sprintf("Invalid %s: must be one of A1, A2, B1, B2, C1, C2", b.field)
+		}
+		*b.level = level
 	}
-	if practiceLanguage == nil {
-		return false, "Invalid practice language"
+
+	if req.PartnerLevelMin != "" && req.PartnerLevelMax != "" && req.PartnerLevelMin.Rank() > req.PartnerLevelMax.Rank() {
+		return false, "partner_level_min cannot be above partner_level_max"
 	}
 
 	return true, ""
 }
 
-func (api *APIService) CancelMatchmaking(w http.ResponseWriter, r *http.Request) {
-	var req CancelMatchmakingRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+// resolveLanguages checks that every named language exists and returns their canonical names
+// in order, without duplicates
+func (api *APIService) resolveLanguages(ctx context.Context, names []string, kind string) ([]string, bool, string) {
+	var resolved []string
+	for _, name := range names {
+		language, err := api.languagesRepository.GetLanguageByName(ctx, name)
+		if err != nil {
+			return nil, false, fmt.Sprintf("Error validating %s languages", kind)
+		}
+		if language == nil {
+			return nil, false, fmt.Sprintf("Invalid %s language: %s", kind, name)
+		}
+		if !slices.Contains(resolved, language.Name) {
+			resolved = append(resolved, language.Name)
+		}
 	}
 
-	ok, msg := api.validateCancelMatchmakingRequest(r.Context(), req)
-	if !ok {
-		http.Error(w, msg, http.StatusBadRequest)
-		return
+	if len(resolved) > maxQueueLanguages {
+		return nil, false, fmt.Sprintf("At most %d %s languages are allowed", maxQueueLanguages, kind)
 	}
+	return resolved, true, ""
+}
 
-	err := api.matchmakingService.CancelMatchmaking(r.Context(), req.UserID)
+// CancelMatchmaking removes the authenticated user from every queue they joined. It takes no
+// request body; one sent by older clients is ignored.
+func (api *APIService) CancelMatchmaking(w http.ResponseWriter, r *http.Request) {
+	err := api.matchmakingService.CancelMatchmaking(r.Context(), auth.UserIDFromContext(r.Context()))
 	if err != nil {
 		http.Error(w, "Failed to remove from queue", http.StatusInternalServerError)
 		return
@@ -119,23 +278,29 @@ func (api *APIService) CancelMatchmaking(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
-func (api *APIService) validateCancelMatchmakingRequest(ctx context.Context, req CancelMatchmakingRequest) (bool, string) {
-	if req.UserID == "" || req.PracticeLanguage == "" {
-		return false, "Missing required fields: user_id, practice_language"
+// GetQueueStatus tells the authenticated user whether and where they are queued, with an
+// estimated wait
+func (api *APIService) GetQueueStatus(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+	if userID != auth.UserIDFromContext(r.Context()) {
+		http.Error(w, "Cannot get another user's queue status", http.StatusForbidden)
+		return
 	}
 
-	language, err := api.languagesRepository.GetLanguageByName(ctx, req.PracticeLanguage)
+	status, err := api.matchmakingService.GetQueueStatus(r.Context(), userID)
 	if err != nil {
-		return false, "Error validating practice language"
-	}
-	if language == nil {
-		return false, "Invalid practice language"
+		log.Printf("Failed to get queue status of user %s: %v", userID, err)
+		http.Error(w, "Failed to get queue status", http.StatusInternalServerError)
+		return
 	}
 
-	return true, ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
 }
 
-func (api *APIService) getWebSocketURL(userID string, r *http.Request) string {
+// getWebSocketURL returns the URL clients connect to for notifications. Clients authenticate
+// the upgrade the same way as REST requests, or with the access_token query parameter.
+func (api *APIService) getWebSocketURL(r *http.Request) string {
 	scheme := "ws"
 	if r.TLS != nil {
 		scheme = "wss"
@@ -146,5 +311,5 @@ func (api *APIService) getWebSocketURL(userID string, r *http.Request) string {
 		host = "localhost:8080"
 	}
 
-	return fmt.Sprintf("%s://%s/ws?user_id=%s", scheme, host, userID)
+	return fmt.Sprintf("%s://%s/ws", scheme, host)
 }
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"langapp-backend/auth"
+	"langapp-backend/moderation"
+	"langapp-backend/session"
+	"langapp-backend/websocket"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultReportPageSize   = 50
+	maxReportPageSize       = 200
+	maxModeratorNoteLength  = 2000
+	maxSuspensionReasonSize = 500
+)
+
+type ListReportsResponse struct {
+	Reports    []moderation.Report `json:"reports"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+type UpdateReportRequest struct {
+	Status        *moderation.ReportStatus `json:"status"`
+	ModeratorNote *string                  `json:"moderator_note"`
+}
+
+// SuspendUserRequest suspends a user for DurationHours, or until lifted if it is zero
+type SuspendUserRequest struct {
+	Reason        string     `json:"reason"`
+	DurationHours int        `json:"duration_hours,omitempty"`
+	ReportID      *uuid.UUID `json:"report_id,omitempty"`
+}
+
+// ListReports returns a page of the moderation queue, newest first
+func (api *APIService) ListReports(w http.ResponseWriter, r *http.Request) {
+	filter, ok, msg := parseReportFilter(r)
+	if !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	reports, next, err := api.moderationRepository.ListReports(r.Context(), filter)
+	if err != nil {
+		log.Printf("Failed to list reports: %v", err)
+		http.Error(w, "Failed to list reports", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListReportsResponse{Reports: reports}
+	if next != nil {
+		response.NextCursor = next.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *APIService) GetReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := uuid.Parse(chi.URLParam(r, "report_id"))
+	if err != nil {
+		http.Error(w, "Invalid report ID", http.StatusBadRequest)
+		return
+	}
+
+	report, err := api.moderationRepository.GetReportByID(r.Context(), reportID)
+	if err != nil {
+		if errors.Is(err, moderation.ErrReportNotFound) {
+			http.Error(w, "Report not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get report %s: %v", reportID, err)
+		http.Error(w, "Failed to get report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// UpdateReport triages a report, recording the calling moderator
+func (api *APIService) UpdateReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := uuid.Parse(chi.URLParam(r, "report_id"))
+	if err != nil {
+		http.Error(w, "Invalid report ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Status == nil && req.ModeratorNote == nil {
+		http.Error(w, "Nothing to update: set status or moderator_note", http.StatusBadRequest)
+		return
+	}
+	if req.Status != nil && !req.Status.IsValid() {
+		http.Error(w, "Invalid status: must be one of open, in_review, resolved, dismissed", http.StatusBadRequest)
+		return
+	}
+	if req.ModeratorNote != nil {
+		note := strings.TrimSpace(*req.ModeratorNote)
+		if len([]rune(note)) > maxModeratorNoteLength {
+			http.Error(w, fmt.Sprintf("moderator_note must be at most %d characters", maxModeratorNoteLength), http.StatusBadRequest)
+			return
+		}
+		req.ModeratorNote = &note
+	}
+
+	moderatorID := auth.UserIDFromContext(r.Context())
+	update := moderation.ReportUpdate{Status: req.Status, Note: req.ModeratorNote}
+
+	report, err := api.moderationRepository.UpdateReport(r.Context(), reportID, moderatorID, update)
+	if err != nil {
+		if errors.Is(err, moderation.ErrReportNotFound) {
+			http.Error(w, "Report not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to update report %s: %v", reportID, err)
+		http.Error(w, "Failed to update report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// SuspendUser suspends a user and removes them from the matchmaking queue. Suspended users
+// cannot join the queue or open a WebSocket connection.
+func (api *APIService) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+
+	var req SuspendUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(req.Reason)) > maxSuspensionReasonSize {
+		http.Error(w, fmt.Sprintf("reason must be at most %d characters", maxSuspensionReasonSize), http.StatusBadRequest)
+		return
+	}
+	if req.DurationHours < 0 {
+		http.Error(w, "duration_hours cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	user, err := api.usersRepository.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get user %s: %v", userID, err)
+		http.Error(w, "Failed to suspend user", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	suspension := &moderation.Suspension{
+		UserID:      userID,
+		Reason:      req.Reason,
+		ReportID:    req.ReportID,
+		SuspendedBy: auth.UserIDFromContext(r.Context()),
+	}
+	if req.DurationHours > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.DurationHours) * time.Hour)
+		suspension.ExpiresAt = &expiresAt
+	}
+
+	if err := api.moderationRepository.SuspendUser(r.Context(), suspension); err != nil {
+		log.Printf("Failed to suspend user %s: %v", userID, err)
+		http.Error(w, "Failed to suspend user", http.StatusInternalServerError)
+		return
+	}
+
+	if err := api.matchmakingService.CancelMatchmaking(r.Context(), userID); err != nil {
+		log.Printf("Failed to remove suspended user %s from the queue: %v", userID, err)
+	}
+	if err := api.wsManager.Disconnect(r.Context(), userID, websocket.CloseSuspended, suspension.Message()); err != nil {
+		log.Printf("Failed to disconnect suspended user %s: %v", userID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(suspension)
+}
+
+// LiftSuspension lifts every active suspension of a user
+func (api *APIService) LiftSuspension(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+
+	lifted, err := api.moderationRepository.LiftSuspensions(r.Context(), userID, auth.UserIDFromContext(r.Context()))
+	if err != nil {
+		log.Printf("Failed to lift suspension of user %s: %v", userID, err)
+		http.Error(w, "Failed to lift suspension", http.StatusInternalServerError)
+		return
+	}
+	if !lifted {
+		http.Error(w, "User is not suspended", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseReportFilter reads the status, category, reported_user_id, limit and cursor query parameters
+func parseReportFilter(r *http.Request) (moderation.ReportFilter, bool, string) {
+	query := r.URL.Query()
+	filter := moderation.ReportFilter{Limit: defaultReportPageSize}
+
+	if status := moderation.ReportStatus(query.Get("status")); status != "" {
+		if !status.IsValid() {
+			return filter, false, "Invalid status: must be one of open, in_review, resolved, dismissed"
+		}
+		filter.Status = status
+	}
+
+	if category := moderation.ReportCategory(query.Get("category")); category != "" {
+		if !category.IsValid() {
+			return filter, false, "Invalid category"
+		}
+		filter.Category = category
+	}
+
+	filter.ReportedUserID = query.Get("reported_user_id")
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxReportPageSize {
+			return filter, false, fmt.Sprintf("Invalid limit: must be between 1 and %d", maxReportPageSize)
+		}
+		filter.Limit = limit
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := session.ParseCursor(value)
+		if err != nil {
+			return filter, false, "Invalid cursor"
+		}
+		filter.After = cursor
+	}
+
+	return filter, true, ""
+}
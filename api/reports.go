@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"langapp-backend/auth"
+	"langapp-backend/moderation"
+	"langapp-backend/session"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const maxReportDescriptionLength = 2000
+
+type SubmitReportRequest struct {
+	Category    moderation.ReportCategory `json:"category"`
+	Description string                    `json:"description"`
+}
+
+// SubmitReport lets a participant report their partner, during or after the session. Each
+// participant can report a session once.
+func (api *APIService) SubmitReport(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(chi.URLParam(r, "session_id"))
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SubmitReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ok, msg := validateSubmitReportRequest(&req)
+	if !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	sess, err := api.sessionRepository.GetSessionByID(r.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, session.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get session %s: %v", sessionID, err)
+		http.Error(w, "Failed to submit report", http.StatusInternalServerError)
+		return
+	}
+
+	userID := auth.UserIDFromContext(r.Context())
+	partnerID, ok := sess.PartnerID(userID)
+	if !ok {
+		http.Error(w, "Not a participant of this session", http.StatusForbidden)
+		return
+	}
+
+	report := &moderation.Report{
+		SessionID:      sessionID,
+		ReporterID:     userID,
+		ReportedUserID: partnerID,
+		Category:       req.Category,
+		Description:    req.Description,
+	}
+
+	if err := api.moderationRepository.CreateReport(r.Context(), report); err != nil {
+		if errors.Is(err, moderation.ErrReportExists) {
+			http.Error(w, "Session already reported", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to store report from %s for session %s: %v", userID, sessionID, err)
+		http.Error(w, "Failed to submit report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+func validateSubmitReportRequest(req *SubmitReportRequest) (bool, string) {
+	req.Category = moderation.ReportCategory(strings.ToLower(strings.TrimSpace(string(req.Category))))
+	if !req.Category.IsValid() {
+		categories := make([]string, len(moderation.Categories))
+		for i, category := range moderation.Categories {
+			categories[i] = string(category)
+		}
+		return false, fmt.Sprintf("Invalid category: must be one of %s", strings.Join(categories, ", "))
+	}
+
+	req.Description = strings.TrimSpace(req.Description)
+	if len([]rune(req.Description)) > maxReportDescriptionLength {
+		return false, fmt.Sprintf("description must be at most %d characters", maxReportDescriptionLength)
+	}
+
+	return true, ""
+}
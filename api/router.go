@@ -2,17 +2,28 @@ package api
 
 import (
 	"context"
+	"langapp-backend/auth"
+	"langapp-backend/blocks"
+	"langapp-backend/feedback"
+	"langapp-backend/health"
 	"langapp-backend/languages"
 	"langapp-backend/matchmaking"
+	"langapp-backend/moderation"
+	"langapp-backend/session"
+	"langapp-backend/users"
 	"langapp-backend/websocket"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type MatchmakingService interface {
-	InitiateMatchmaking(ctx context.Context, userID, nativeLanguage, practiceLanguage string) (*matchmaking.QueueEntry, error)
+	InitiateMatchmaking(ctx context.Context, userID string, nativeLanguages, practiceLanguages []string, preferences matchmaking.MatchPreferences) (*matchmaking.QueueEntry, error)
 	CancelMatchmaking(ctx context.Context, userID string) error
+	GetQueueStatus(ctx context.Context, userID string) (*matchmaking.QueueStatus, error)
 }
 
 type LanguagesRepository interface {
@@ -20,30 +31,105 @@ type LanguagesRepository interface {
 	GetLanguageByName(ctx context.Context, name string) (*languages.Language, error)
 }
 
+type UsersRepository interface {
+	CreateUser(ctx context.Context, user *users.User) error
+	GetUserByID(ctx context.Context, userID string) (*users.User, error)
+	UpdateUser(ctx context.Context, userID string, update users.UserUpdate) (*users.User, error)
+	EnsureUser(ctx context.Context, userID string) error
+}
+
+type SessionRepository interface {
+	GetSessionByID(ctx context.Context, sessionID uuid.UUID) (*session.Session, error)
+	ListSessionsByUserID(ctx context.Context, userID string, filter session.SessionFilter) ([]session.Session, *session.Cursor, error)
+	GetUserStats(ctx context.Context, userID string, loc *time.Location) (*session.UserStats, error)
+}
+
+type FeedbackRepository interface {
+	CreateFeedback(ctx context.Context, feedback *feedback.Feedback) error
+	GetReputation(ctx context.Context, userID string) (*feedback.Reputation, error)
+}
+
+type BlockService interface {
+	Block(ctx context.Context, blockerID, blockedID string) error
+	Unblock(ctx context.Context, blockerID, blockedID string) (bool, error)
+	ListBlocks(ctx context.Context, blockerID string) ([]blocks.Block, error)
+}
+
+type ModerationRepository interface {
+	CreateReport(ctx context.Context, report *moderation.Report) error
+	GetReportByID(ctx context.Context, reportID uuid.UUID) (*moderation.Report, error)
+	ListReports(ctx context.Context, filter moderation.ReportFilter) ([]moderation.Report, *session.Cursor, error)
+	UpdateReport(ctx context.Context, reportID uuid.UUID, moderatorID string, update moderation.ReportUpdate) (*moderation.Report, error)
+	SuspendUser(ctx context.Context, suspension *moderation.Suspension) error
+	LiftSuspensions(ctx context.Context, userID, liftedBy string) (bool, error)
+	GetActiveSuspension(ctx context.Context, userID string) (*moderation.Suspension, error)
+}
+
 type APIService struct {
-	matchmakingService  MatchmakingService
-	languagesRepository LanguagesRepository
-	wsManager           *websocket.Manager
+	matchmakingService   MatchmakingService
+	languagesRepository  LanguagesRepository
+	usersRepository      UsersRepository
+	sessionRepository    SessionRepository
+	feedbackRepository   FeedbackRepository
+	blockService         BlockService
+	moderationRepository ModerationRepository
+	wsManager            *websocket.Manager
 }
 
-func NewAPIService(matchmakingService MatchmakingService, languagesRepository LanguagesRepository, wsManager *websocket.Manager) *APIService {
+func NewAPIService(matchmakingService MatchmakingService, languagesRepository LanguagesRepository, usersRepository UsersRepository, sessionRepository SessionRepository, feedbackRepository FeedbackRepository, blockService BlockService, moderationRepository ModerationRepository, wsManager *websocket.Manager) *APIService {
 	return &APIService{
-		matchmakingService:  matchmakingService,
-		languagesRepository: languagesRepository,
-		wsManager:           wsManager,
+		matchmakingService:   matchmakingService,
+		languagesRepository:  languagesRepository,
+		usersRepository:      usersRepository,
+		sessionRepository:    sessionRepository,
+		feedbackRepository:   feedbackRepository,
+		blockService:         blockService,
+		moderationRepository: moderationRepository,
+		wsManager:            wsManager,
 	}
 }
 
-func NewRouter(apiService *APIService) *chi.Mux {
+func NewRouter(apiService *APIService, healthChecker *health.Checker, authenticator auth.Authenticator) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
 	r.Get("/languages", apiService.GetLanguagesHandler)
-	r.Post("/queue", apiService.StartMatchmaking)
-	r.Delete("/queue", apiService.CancelMatchmaking)
-	r.HandleFunc("/ws", apiService.wsManager.HandleWebSocket)
+	r.Handle("/metrics", promhttp.Handler())
+	r.Get("/healthz", healthChecker.Liveness)
+	r.Get("/readyz", healthChecker.Readiness)
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Middleware(authenticator))
+
+		r.Post("/queue", apiService.StartMatchmaking)
+		r.Delete("/queue", apiService.CancelMatchmaking)
+		r.Get("/queue/{user_id}", apiService.GetQueueStatus)
+		r.Post("/users", apiService.CreateUser)
+		r.Get("/users/{user_id}", apiService.GetUser)
+		r.Patch("/users/{user_id}", apiService.UpdateUser)
+		r.Get("/users/{user_id}/sessions", apiService.ListUserSessions)
+		r.Get("/users/{user_id}/stats", apiService.GetUserStats)
+		r.Get("/users/{user_id}/reputation", apiService.GetUserReputation)
+		r.Get("/blocks", apiService.ListBlocks)
+		r.Post("/blocks", apiService.BlockUser)
+		r.Delete("/blocks/{user_id}", apiService.UnblockUser)
+		r.Get("/sessions/{session_id}", apiService.GetSession)
+		r.Post("/sessions/{session_id}/feedback", apiService.SubmitFeedback)
+		r.Post("/sessions/{session_id}/reports", apiService.SubmitReport)
+		r.HandleFunc("/ws", apiService.wsManager.HandleWebSocket)
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(auth.RequireRole(auth.RoleAdmin))
+
+			r.Get("/reports", apiService.ListReports)
+			r.Get("/reports/{report_id}", apiService.GetReport)
+			r.Patch("/reports/{report_id}", apiService.UpdateReport)
+			r.Post("/users/{user_id}/suspension", apiService.SuspendUser)
+			r.Delete("/users/{user_id}/suspension", apiService.LiftSuspension)
+		})
+	})
 
 	return r
 }
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"langapp-backend/auth"
+	"langapp-backend/session"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultSessionPageSize = 20
+	maxSessionPageSize     = 100
+)
+
+type ListSessionsResponse struct {
+	Sessions   []session.Session `json:"sessions"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// GetSession returns a session to one of its participants
+func (api *APIService) GetSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(chi.URLParam(r, "session_id"))
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	sess, err := api.sessionRepository.GetSessionByID(r.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, session.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get session %s: %v", sessionID, err)
+		http.Error(w, "Failed to get session", http.StatusInternalServerError)
+		return
+	}
+
+	if _, ok := sess.PartnerID(auth.UserIDFromContext(r.Context())); !ok {
+		http.Error(w, "Not a participant of this session", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sess)
+}
+
+// ListUserSessions returns a page of the authenticated user's own session history
+func (api *APIService) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+	if userID != auth.UserIDFromContext(r.Context()) {
+		http.Error(w, "Cannot list another user's sessions", http.StatusForbidden)
+		return
+	}
+
+	filter, ok, msg := api.parseSessionFilter(r)
+	if !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	sessions, next, err := api.sessionRepository.ListSessionsByUserID(r.Context(), userID, filter)
+	if err != nil {
+		log.Printf("Failed to list sessions of user %s: %v", userID, err)
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListSessionsResponse{Sessions: sessions}
+	if next != nil {
+		response.NextCursor = next.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetUserStats returns the authenticated user's own practice statistics. Streak days follow
+// the time zone of the user's profile, or UTC if it has none.
+func (api *APIService) GetUserStats(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+	if userID != auth.UserIDFromContext(r.Context()) {
+		http.Error(w, "Cannot get another user's stats", http.StatusForbidden)
+		return
+	}
+
+	user, err := api.usersRepository.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get user %s: %v", userID, err)
+		http.Error(w, "Failed to get user stats", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	loc := time.UTC
+	if user.Timezone != "" {
+		if userLoc, err := time.LoadLocation(user.Timezone); err == nil {
+			loc = userLoc
+		}
+	}
+
+	stats, err := api.sessionRepository.GetUserStats(r.Context(), userID, loc)
+	if err != nil {
+		log.Printf("Failed to get stats of user %s: %v", userID, err)
+		http.Error(w, "Failed to get user stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// parseSessionFilter reads the language, status, from, to, limit and cursor query parameters.
+// Dates are RFC 3339 timestamps or YYYY-MM-DD days; a day given as to is included.
+func (api *APIService) parseSessionFilter(r *http.Request) (session.SessionFilter, bool, string) {
+	query := r.URL.Query()
+	filter := session.SessionFilter{Limit: defaultSessionPageSize}
+
+	if name := query.Get("language"); name != "" {
+		language, err := api.languagesRepository.GetLanguageByName(r.Context(), name)
+		if err != nil {
+			return filter, false, "Error validating language"
+		}
+		if language == nil {
+			return filter, false, "Invalid language"
+		}
+		filter.Language = language.Name
+	}
+
+	if status := session.SessionStatus(query.Get("status")); status != "" {
+		if !status.IsValid() {
+			return filter, false, "Invalid status: must be one of proposed, matched, connecting, active, completed, failed"
+		}
+		filter.Status = status
+	}
+
+	if value := query.Get("from"); value != "" {
+		from, _, ok := parseDateParam(value)
+		if !ok {
+			return filter, false, "Invalid from: must be an RFC 3339 timestamp or a YYYY-MM-DD date"
+		}
+		filter.From = from
+	}
+
+	if value := query.Get("to"); value != "" {
+		to, isDay, ok := parseDateParam(value)
+		if !ok {
+			return filter, false, "Invalid to: must be an RFC 3339 timestamp or a YYYY-MM-DD date"
+		}
+		if isDay {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = to
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, false, "from must be before to"
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSessionPageSize {
+			return filter, false, "Invalid limit: must be between 1 and 100"
+		}
+		filter.Limit = limit
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := session.ParseCursor(value)
+		if err != nil {
+			return filter, false, "Invalid cursor"
+		}
+		filter.After = cursor
+	}
+
+	return filter, true, ""
+}
+
+// parseDateParam parses an RFC 3339 timestamp or a YYYY-MM-DD day (UTC), reporting which it was
+func parseDateParam(value string) (time.Time, bool, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, true
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, true, true
+	}
+	return time.Time{}, false, false
+}
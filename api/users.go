@@ -0,0 +1,279 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"langapp-backend/auth"
+	"langapp-backend/languages"
+	"langapp-backend/users"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type LearningLanguageRequest struct {
+	Language    string `json:"language"`
+	Proficiency string `json:"proficiency"`
+}
+
+type CreateUserRequest struct {
+	DisplayName       string                    `json:"display_name"`
+	NativeLanguages   []string                  `json:"native_languages"`
+	LearningLanguages []LearningLanguageRequest `json:"learning_languages"`
+	Timezone          string                    `json:"timezone"`
+	AvatarURL         string                    `json:"avatar_url"`
+}
+
+// UpdateUserRequest changes only the fields present in the request body
+type UpdateUserRequest struct {
+	DisplayName       *string                   `json:"display_name"`
+	NativeLanguages   []string                  `json:"native_languages"`
+	LearningLanguages []LearningLanguageRequest `json:"learning_languages"`
+	Timezone          *string                   `json:"timezone"`
+	AvatarURL         *string                   `json:"avatar_url"`
+}
+
+const maxDisplayNameLength = 100
+
+// CreateUser creates the profile of the authenticated user
+func (api *APIService) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if ok, msg := validateDisplayName(req.DisplayName); !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if ok, msg := validateProfileDetails(req.Timezone, req.AvatarURL); !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	nativeLanguages, learningLanguages, ok, msg := api.normalizeProfileLanguages(r.Context(), req.NativeLanguages, req.LearningLanguages)
+	if !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	user := &users.User{
+		ID:                auth.UserIDFromContext(r.Context()),
+		DisplayName:       strings.TrimSpace(req.DisplayName),
+		NativeLanguages:   nativeLanguages,
+		LearningLanguages: learningLanguages,
+		Timezone:          req.Timezone,
+		AvatarURL:         req.AvatarURL,
+	}
+	if user.NativeLanguages == nil {
+		user.NativeLanguages = []string{}
+	}
+	if user.LearningLanguages == nil {
+		user.LearningLanguages = []users.LearningLanguage{}
+	}
+
+	if err := api.usersRepository.CreateUser(r.Context(), user); err != nil {
+		if errors.Is(err, users.ErrUserExists) {
+			http.Error(w, "User profile already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to create user %s: %v", user.ID, err)
+		http.Error(w, "Failed to create user profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+func (api *APIService) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+
+	user, err := api.usersRepository.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get user %s: %v", userID, err)
+		http.Error(w, "Failed to get user profile", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateUser updates the profile of the authenticated user; other users' profiles cannot be changed
+func (api *APIService) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+	if userID != auth.UserIDFromContext(r.Context()) {
+		http.Error(w, "Cannot update another user's profile", http.StatusForbidden)
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	update := users.UserUpdate{
+		Timezone:  req.Timezone,
+		AvatarURL: req.AvatarURL,
+	}
+
+	if req.DisplayName != nil {
+		if ok, msg := validateDisplayName(*req.DisplayName); !ok {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		displayName := strings.TrimSpace(*req.DisplayName)
+		update.DisplayName = &displayName
+	}
+
+	var timezone, avatarURL string
+	if req.Timezone != nil {
+		timezone = *req.Timezone
+	}
+	if req.AvatarURL != nil {
+		avatarURL = *req.AvatarURL
+	}
+	if ok, msg := validateProfileDetails(timezone, avatarURL); !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// A list left out of the request keeps its stored languages, which are checked together with
+	// the new list so that a language cannot end up both native and learning
+	native, learning := req.NativeLanguages, req.LearningLanguages
+	if (native == nil) != (learning == nil) {
+		current, err := api.usersRepository.GetUserByID(r.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to get user %s: %v", userID, err)
+			http.Error(w, "Failed to update user profile", http.StatusInternalServerError)
+			return
+		}
+		if current == nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if native == nil {
+			native = current.NativeLanguages
+		}
+		if learning == nil {
+			for _, l := range current.LearningLanguages {
+				learning = append(learning, LearningLanguageRequest{Language: l.Language, Proficiency: string(l.Proficiency)})
+			}
+		}
+	}
+
+	nativeLanguages, learningLanguages, ok, msg := api.normalizeProfileLanguages(r.Context(), native, learning)
+	if !ok {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if req.NativeLanguages != nil {
+		update.NativeLanguages = append([]string{}, nativeLanguages...)
+	}
+	if req.LearningLanguages != nil {
+		update.LearningLanguages = append([]users.LearningLanguage{}, learningLanguages...)
+	}
+
+	user, err := api.usersRepository.UpdateUser(r.Context(), userID, update)
+	if err != nil {
+		log.Printf("Failed to update user %s: %v", userID, err)
+		http.Error(w, "Failed to update user profile", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func validateDisplayName(displayName string) (bool, string) {
+	displayName = strings.TrimSpace(displayName)
+	if displayName == "" {
+		return false, "Missing required field: display_name"
+	}
+	if len([]rune(displayName)) > maxDisplayNameLength {
+		return false, fmt.Sprintf("display_name must be at most %d characters", maxDisplayNameLength)
+	}
+	return true, ""
+}
+
+// validateProfileDetails checks the optional timezone and avatar URL; empty values are allowed
+func validateProfileDetails(timezone, avatarURL string) (bool, string) {
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return false, "Invalid timezone: must be an IANA time zone such as 'Europe/Berlin'"
+		}
+	}
+
+	if avatarURL != "" {
+		u, err := url.Parse(avatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return false, "Invalid avatar_url: must be an http or https URL"
+		}
+	}
+
+	return true, ""
+}
+
+// normalizeProfileLanguages validates the profile languages and replaces them with their
+// canonical names, so that "es" and "Spanish" are stored the same way
+func (api *APIService) normalizeProfileLanguages(ctx context.Context, native []string, learning []LearningLanguageRequest) ([]string, []users.LearningLanguage, bool, string) {
+	seen := make(map[string]bool)
+
+	var nativeLanguages []string
+	for _, name := range native {
+		language, err := api.languagesRepository.GetLanguageByName(ctx, name)
+		if err != nil {
+			return nil, nil, false, "Error validating native languages"
+		}
+		if language == nil {
+			return nil, nil, false, fmt.Sprintf("Invalid native language: %s", name)
+		}
+		if seen[language.Name] {
+			return nil, nil, false, fmt.Sprintf("Language listed more than once: %s", language.Name)
+		}
+		seen[language.Name] = true
+		nativeLanguages = append(nativeLanguages, language.Name)
+	}
+
+	var learningLanguages []users.LearningLanguage
+	for _, l := range learning {
+		language, err := api.languagesRepository.GetLanguageByName(ctx, l.Language)
+		if err != nil {
+			return nil, nil, false, "Error validating learning languages"
+		}
+		if language == nil {
+			return nil, nil, false, fmt.Sprintf("Invalid learning language: %s", l.Language)
+		}
+		if seen[language.Name] {
+			return nil, nil, false, fmt.Sprintf("Language listed more than once: %s", language.Name)
+		}
+		seen[language.Name] = true
+
+		proficiency, ok := languages.ParseLevel(l.Proficiency)
+		if !ok {
+			return nil, nil, false, fmt.Sprintf("Invalid proficiency for %s: must be one of A1, A2, B1, B2, C1, C2", language.Name)
+		}
+		learningLanguages = append(learningLanguages, users.LearningLanguage{Language: language.Name, Proficiency: proficiency})
+	}
+
+	return nativeLanguages, learningLanguages, true, ""
+}
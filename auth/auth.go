@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type contextKey struct{}
+
+var (
+	ErrMissingCredentials = errors.New("missing credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// RoleAdmin grants access to the moderation API
+const RoleAdmin = "admin"
+
+// Identity is the verified caller of a request
+type Identity struct {
+	UserID string
+	Roles  []string
+}
+
+// HasRole reports whether the identity was granted the given role
+func (i *Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies the credentials of an incoming request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Claims are the JWT claims accepted by TokenAuthenticator. The subject is the user ID.
+type Claims struct {
+	Roles []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenAuthenticator verifies HMAC-SHA256 signed JWT bearer tokens
+type TokenAuthenticator struct {
+	key    []byte
+	parser *jwt.Parser
+}
+
+func NewTokenAuthenticator(key []byte, issuer string) *TokenAuthenticator {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+
+	return &TokenAuthenticator{
+		key:    key,
+		parser: jwt.NewParser(options...),
+	}
+}
+
+func (ta *TokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		return nil, ErrMissingCredentials
+	}
+
+	var claims Claims
+	_, err := ta.parser.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		return ta.key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrInvalidCredentials)
+	}
+
+	return &Identity{UserID: claims.Subject, Roles: claims.Roles}, nil
+}
+
+// DevAuthenticator trusts the X-User-ID header, or the user_id query parameter for WebSocket
+// upgrades, without any verification. It must only be used for local development.
+type DevAuthenticator struct{}
+
+func NewDevAuthenticator() *DevAuthenticator {
+	log.Println("WARNING: authentication is in development mode, caller-supplied user IDs are trusted")
+	return &DevAuthenticator{}
+}
+
+func (da *DevAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		userID = r.URL.Query().Get("user_id")
+	}
+	if userID == "" {
+		return nil, ErrMissingCredentials
+	}
+
+	var roles []string
+	if rolesHeader := r.Header.Get("X-User-Roles"); rolesHeader != "" {
+		roles = strings.Split(rolesHeader, ",")
+	}
+
+	return &Identity{UserID: userID, Roles: roles}, nil
+}
+
+// Middleware rejects requests that fail authentication and stores the verified identity
+// in the request context
+func Middleware(authenticator Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, err := authenticator.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="langapp"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+		})
+	}
+}
+
+// RequireRole rejects requests whose identity, stored by Middleware, lacks role
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity := IdentityFromContext(r.Context())
+			if identity == nil || !identity.HasRole(role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// IdentityFromContext returns the identity stored by Middleware, or nil
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(contextKey{}).(*Identity)
+	return identity
+}
+
+// UserIDFromContext returns the authenticated user ID, or an empty string
+func UserIDFromContext(ctx context.Context) string {
+	if identity := IdentityFromContext(ctx); identity != nil {
+		return identity.UserID
+	}
+	return ""
+}
+
+// bearerToken extracts the token from the Authorization header. Browsers cannot set headers
+// on WebSocket upgrades, so the access_token query parameter is accepted as well.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("access_token")
+}
@@ -0,0 +1,234 @@
+package blocks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"langapp-backend/storage/postgres"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Block struct {
+	BlockedID string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Repository struct {
+	db *postgres.PostgresClient
+}
+
+func NewRepository(db *postgres.PostgresClient) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+// CreateBlock records that blocker blocked blocked; blocking someone twice is a no-op
+func (r *Repository) CreateBlock(ctx context.Context, blockerID, blockedID string) error {
+	_, err := r.db.Exec(ctx,
+		"INSERT INTO user_blocks (blocker_id, blocked_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		blockerID, blockedID,
+	)
+	if err != nil {
+		return fmt.Errorf("error querying database: %v", err)
+	}
+	return nil
+}
+
+// DeleteBlock removes a block and reports whether it existed
+func (r *Repository) DeleteBlock(ctx context.Context, blockerID, blockedID string) (bool, error) {
+	tag, err := r.db.Exec(ctx,
+		"DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2",
+		blockerID, blockedID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error querying database: %v", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListBlocks returns the users blocked by blockerID, most recent first
+func (r *Repository) ListBlocks(ctx context.Context, blockerID string) ([]Block, error) {
+	rows, err := r.db.Query(ctx,
+		"SELECT blocked_id, created_at FROM user_blocks WHERE blocker_id = $1 ORDER BY created_at DESC",
+		blockerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	blocks := []Block{}
+	for rows.Next() {
+		var block Block
+		if err := rows.Scan(&block.BlockedID, &block.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning block: %v", err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, rows.Err()
+}
+
+// ListExcludedIDs returns every user that must not be matched with userID: those they blocked
+// and those who blocked them
+func (r *Repository) ListExcludedIDs(ctx context.Context, userID string) ([]string, error) {
+	query := `
+		SELECT blocked_id FROM user_blocks WHERE blocker_id = $1
+		UNION
+		SELECT blocker_id FROM user_blocks WHERE blocked_id = $1`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning block: %v", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	return userIDs, rows.Err()
+}
+
+type RedisClient interface {
+	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	SIsMember(ctx context.Context, key string, member interface{}) *redis.BoolCmd
+	TxPipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error)
+	Watch(ctx context.Context, fn func(*redis.Tx) error, keys ...string) error
+}
+
+const (
+	// Set of the users a user must not be matched with, loaded from Postgres on first use
+	excludedKeyPrefix = "blocks:"
+	excludedCacheTTL  = time.Hour
+
+	// Counter bumped whenever a block of the user changes, so that a load that read Postgres
+	// before the change does not cache what it read
+	versionKeyPrefix = "blocks:version:"
+
+	// Member that marks a loaded set, so that users without blocks are cached as well.
+	// User IDs are never empty.
+	loadedMarker = ""
+)
+
+// BlockService manages block lists in Postgres and answers block checks for matchmaking from
+// a Redis cache, which is invalidated whenever a block changes. Loads watch a version counter
+// that every change bumps, so a set read before a change is never cached after it.
+type BlockService struct {
+	repository  *Repository
+	redisClient RedisClient
+}
+
+func NewBlockService(repository *Repository, redisClient RedisClient) *BlockService {
+	return &BlockService{
+		repository:  repository,
+		redisClient: redisClient,
+	}
+}
+
+func (bs *BlockService) Block(ctx context.Context, blockerID, blockedID string) error {
+	if err := bs.repository.CreateBlock(ctx, blockerID, blockedID); err != nil {
+		return err
+	}
+	return bs.invalidate(ctx, blockerID, blockedID)
+}
+
+// Unblock removes a block and reports whether it existed
+func (bs *BlockService) Unblock(ctx context.Context, blockerID, blockedID string) (bool, error) {
+	removed, err := bs.repository.DeleteBlock(ctx, blockerID, blockedID)
+	if err != nil || !removed {
+		return removed, err
+	}
+	return true, bs.invalidate(ctx, blockerID, blockedID)
+}
+
+func (bs *BlockService) ListBlocks(ctx context.Context, blockerID string) ([]Block, error) {
+	return bs.repository.ListBlocks(ctx, blockerID)
+}
+
+// IsBlocked reports whether either user blocked the other
+func (bs *BlockService) IsBlocked(ctx context.Context, userID, otherUserID string) (bool, error) {
+	key := excludedKeyPrefix + userID
+
+	exists, err := bs.redisClient.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check block cache of user '%s': %w", userID, err)
+	}
+	if exists == 0 {
+		excluded, err := bs.load(ctx, userID)
+		if err != nil {
+			return false, err
+		}
+		return slices.Contains(excluded, otherUserID), nil
+	}
+
+	blocked, err := bs.redisClient.SIsMember(ctx, key, otherUserID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to read block cache of user '%s': %w", userID, err)
+	}
+	return blocked, nil
+}
+
+// load reads the users excluded for userID from Postgres and caches them. The set is not cached
+// if a block of the user changed meanwhile; the next check loads it again.
+func (bs *BlockService) load(ctx context.Context, userID string) ([]string, error) {
+	var (
+		excluded []string
+		loadErr  error
+	)
+
+	err := bs.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		excluded, loadErr = bs.repository.ListExcludedIDs(ctx, userID)
+		if loadErr != nil {
+			return loadErr
+		}
+
+		members := []interface{}{loadedMarker}
+		for _, id := range excluded {
+			members = append(members, id)
+		}
+
+		key := excludedKeyPrefix + userID
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, key, members...)
+			pipe.Expire(ctx, key, excludedCacheTTL)
+			return nil
+		})
+		return err
+	}, versionKeyPrefix+userID)
+
+	if loadErr != nil {
+		return nil, fmt.Errorf("failed to load blocks of user '%s': %w", userID, loadErr)
+	}
+	if err != nil && !errors.Is(err, redis.TxFailedErr) {
+		return nil, fmt.Errorf("failed to cache blocks of user '%s': %w", userID, err)
+	}
+	return excluded, nil
+}
+
+// invalidate drops the cached sets of both users, which are reloaded on the next check, and
+// bumps their versions so that loads in progress do not cache what they read
+func (bs *BlockService) invalidate(ctx context.Context, userIDs ...string) error {
+	_, err := bs.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userID := range userIDs {
+			pipe.Del(ctx, excludedKeyPrefix+userID)
+			pipe.Incr(ctx, versionKeyPrefix+userID)
+			pipe.Expire(ctx, versionKeyPrefix+userID, excludedCacheTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invalidate block cache: %w", err)
+	}
+	return nil
+}
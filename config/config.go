@@ -0,0 +1,386 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the runtime settings of the server. Values are taken from the defaults, then
+// the optional YAML config file, then environment variables, each overriding the previous.
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Redis       RedisConfig       `yaml:"redis"`
+	Postgres    PostgresConfig    `yaml:"postgres"`
+	Matchmaking MatchmakingConfig `yaml:"matchmaking"`
+	WebSocket   WebSocketConfig   `yaml:"websocket"`
+	Auth        AuthConfig        `yaml:"auth"`
+}
+
+type ServerConfig struct {
+	ListenAddr string `yaml:"listen_addr"`
+	// NodeID identifies this instance to the others; a random ID is used when empty
+	NodeID string `yaml:"node_id"`
+	// ShutdownTimeout bounds how long a graceful shutdown may take after SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type RedisConfig struct {
+	// URL, when set, takes precedence over Addr, Password, DB and TLS
+	// (e.g. rediss://:password@host:6380/1)
+	URL      string `yaml:"url"`
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	TLS      bool   `yaml:"tls"`
+}
+
+type PostgresConfig struct {
+	// DSN, when set, takes precedence over the individual connection settings
+	DSN      string `yaml:"dsn"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+	SSLMode  string `yaml:"sslmode"`
+	MaxConns int32  `yaml:"max_conns"`
+	MinConns int32  `yaml:"min_conns"`
+}
+
+type MatchmakingConfig struct {
+	// HoldTTL is how long a user may be held for a match before the reaper restores them
+	HoldTTL            time.Duration `yaml:"hold_ttl"`
+	HoldReaperInterval time.Duration `yaml:"hold_reaper_interval"`
+	// MaxWait is how long a user may wait in the queue before matchmaking is cancelled
+	MaxWait         time.Duration `yaml:"max_wait"`
+	SweeperInterval time.Duration `yaml:"sweeper_interval"`
+	// LevelWidenInterval is how long a user waits before their accepted partner level range
+	// grows by one CEFR level in each direction
+	LevelWidenInterval time.Duration `yaml:"level_widen_interval"`
+	// ThroughputWindow is how far back matches are counted to estimate queue wait times
+	ThroughputWindow time.Duration `yaml:"throughput_window"`
+	// AcceptTimeout is how long both matched users have to accept the match. It must be shorter
+	// than HoldTTL, since the users stay on hold meanwhile.
+	AcceptTimeout time.Duration `yaml:"accept_timeout"`
+	// RequeueHeldOnShutdown restores users held by this instance to their queue on shutdown
+	RequeueHeldOnShutdown bool `yaml:"requeue_held_on_shutdown"`
+}
+
+type WebSocketConfig struct {
+	// AllowedOrigins lists the origins allowed to open WebSocket connections. "*" allows any
+	// origin; requests without an Origin header (non-browser clients) are always allowed.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// PingInterval is how often the server pings each client; a client that has not answered
+	// with a pong (or any other message) within PongTimeout is disconnected
+	PingInterval time.Duration `yaml:"ping_interval"`
+	PongTimeout  time.Duration `yaml:"pong_timeout"`
+	// WriteTimeout bounds each write to a client, so a slow client cannot block its writer
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// HandlerTimeout bounds the handling of each inbound message. Messages are handled on the
+	// connection's read loop, so it must stay well under PongTimeout for pongs to be read in time.
+	HandlerTimeout time.Duration `yaml:"handler_timeout"`
+	// MaxMessageSize is the largest inbound message accepted, in bytes
+	MaxMessageSize int `yaml:"max_message_size"`
+	// DuplicatePolicy decides what happens when a connected user opens another connection:
+	// "replace" closes the old one, "reject" refuses the new one and "multi" keeps both and
+	// delivers every message to each of them
+	DuplicatePolicy string `yaml:"duplicate_policy"`
+	// OutboxTTL is how long the messages sent to a user are kept for replay when they connect,
+	// and OutboxMaxLength how many of them at most
+	OutboxTTL       time.Duration `yaml:"outbox_ttl"`
+	OutboxMaxLength int           `yaml:"outbox_max_length"`
+}
+
+type AuthConfig struct {
+	HMACSecret string `yaml:"hmac_secret"`
+	Issuer     string `yaml:"issuer"`
+	// DevMode trusts caller-supplied user IDs and must never be enabled in production
+	DevMode bool `yaml:"dev_mode"`
+}
+
+func defaults() Config {
+	return Config{
+		Server: ServerConfig{
+			ListenAddr:      ":8080",
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Redis: RedisConfig{
+			Addr: "localhost:6379",
+		},
+		Postgres: PostgresConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "langapp",
+			Password: "langapp_dev",
+			Database: "langapp",
+			SSLMode:  "disable",
+			MaxConns: 25,
+			MinConns: 5,
+		},
+		Matchmaking: MatchmakingConfig{
+			HoldTTL:            30 * time.Second,
+			HoldReaperInterval: 15 * time.Second,
+			MaxWait:            5 * time.Minute,
+			SweeperInterval:    10 * time.Second,
+			LevelWidenInterval: 30 * time.Second,
+			ThroughputWindow:   15 * time.Minute,
+			AcceptTimeout:      20 * time.Second,
+
+			RequeueHeldOnShutdown: true,
+		},
+		WebSocket: WebSocketConfig{
+			AllowedOrigins:  []string{"*"},
+			PingInterval:    25 * time.Second,
+			PongTimeout:     60 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			HandlerTimeout:  10 * time.Second,
+			MaxMessageSize:  64 * 1024,
+			DuplicatePolicy: "replace",
+			OutboxTTL:       10 * time.Minute,
+			OutboxMaxLength: 100,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the YAML file at path (skipped when path
+// is empty) and the environment, and validates the result
+func Load(path string) (*Config, error) {
+	cfg := defaults()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file '%s': %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	var errs []error
+
+	setString(&c.Server.ListenAddr, "LISTEN_ADDR")
+	setString(&c.Server.NodeID, "NODE_ID")
+	errs = append(errs, setDuration(&c.Server.ShutdownTimeout, "SHUTDOWN_TIMEOUT"))
+
+	setString(&c.Redis.URL, "REDIS_URL")
+	setString(&c.Redis.Addr, "REDIS_ADDR")
+	setString(&c.Redis.Password, "REDIS_PASSWORD")
+	errs = append(errs, setInt(&c.Redis.DB, "REDIS_DB"))
+	errs = append(errs, setBool(&c.Redis.TLS, "REDIS_TLS"))
+
+	setString(&c.Postgres.DSN, "POSTGRES_DSN")
+	setString(&c.Postgres.Host, "POSTGRES_HOST")
+	errs = append(errs, setInt(&c.Postgres.Port, "POSTGRES_PORT"))
+	setString(&c.Postgres.User, "POSTGRES_USER")
+	setString(&c.Postgres.Password, "POSTGRES_PASSWORD")
+	setString(&c.Postgres.Database, "POSTGRES_DB")
+	setString(&c.Postgres.SSLMode, "POSTGRES_SSLMODE")
+	errs = append(errs, setInt32(&c.Postgres.MaxConns, "POSTGRES_MAX_CONNS"))
+	errs = append(errs, setInt32(&c.Postgres.MinConns, "POSTGRES_MIN_CONNS"))
+
+	errs = append(errs, setDuration(&c.Matchmaking.HoldTTL, "MATCHMAKING_HOLD_TTL"))
+	errs = append(errs, setDuration(&c.Matchmaking.HoldReaperInterval, "MATCHMAKING_HOLD_REAPER_INTERVAL"))
+	errs = append(errs, setDuration(&c.Matchmaking.MaxWait, "MATCHMAKING_MAX_WAIT"))
+	errs = append(errs, setDuration(&c.Matchmaking.SweeperInterval, "MATCHMAKING_SWEEPER_INTERVAL"))
+	errs = append(errs, setDuration(&c.Matchmaking.LevelWidenInterval, "MATCHMAKING_LEVEL_WIDEN_INTERVAL"))
+	errs = append(errs, setDuration(&c.Matchmaking.ThroughputWindow, "MATCHMAKING_THROUGHPUT_WINDOW"))
+	errs = append(errs, setDuration(&c.Matchmaking.AcceptTimeout, "MATCHMAKING_ACCEPT_TIMEOUT"))
+	errs = append(errs, setBool(&c.Matchmaking.RequeueHeldOnShutdown, "MATCHMAKING_REQUEUE_HELD_ON_SHUTDOWN"))
+
+	if value, ok := os.LookupEnv("WEBSOCKET_ALLOWED_ORIGINS"); ok {
+		c.WebSocket.AllowedOrigins = splitList(value)
+	}
+	errs = append(errs, setDuration(&c.WebSocket.PingInterval, "WEBSOCKET_PING_INTERVAL"))
+	errs = append(errs, setDuration(&c.WebSocket.PongTimeout, "WEBSOCKET_PONG_TIMEOUT"))
+	errs = append(errs, setDuration(&c.WebSocket.WriteTimeout, "WEBSOCKET_WRITE_TIMEOUT"))
+	errs = append(errs, setDuration(&c.WebSocket.HandlerTimeout, "WEBSOCKET_HANDLER_TIMEOUT"))
+	errs = append(errs, setInt(&c.WebSocket.MaxMessageSize, "WEBSOCKET_MAX_MESSAGE_SIZE"))
+	setString(&c.WebSocket.DuplicatePolicy, "WEBSOCKET_DUPLICATE_POLICY")
+	errs = append(errs, setDuration(&c.WebSocket.OutboxTTL, "WEBSOCKET_OUTBOX_TTL"))
+	errs = append(errs, setInt(&c.WebSocket.OutboxMaxLength, "WEBSOCKET_OUTBOX_MAX_LENGTH"))
+
+	setString(&c.Auth.HMACSecret, "AUTH_HMAC_SECRET")
+	setString(&c.Auth.Issuer, "AUTH_ISSUER")
+	errs = append(errs, setBool(&c.Auth.DevMode, "AUTH_DEV_MODE"))
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once so that they can all be fixed before the next start
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.ListenAddr == "" {
+		errs = append(errs, errors.New("server.listen_addr (LISTEN_ADDR) must not be empty"))
+	}
+
+	if c.Redis.URL != "" {
+		if _, err := url.Parse(c.Redis.URL); err != nil {
+			errs = append(errs, fmt.Errorf("redis.url (REDIS_URL) is invalid: %v", err))
+		}
+	} else if c.Redis.Addr == "" {
+		errs = append(errs, errors.New("redis.addr (REDIS_ADDR) or redis.url (REDIS_URL) is required"))
+	}
+	if c.Redis.DB < 0 {
+		errs = append(errs, errors.New("redis.db (REDIS_DB) must not be negative"))
+	}
+
+	if c.Postgres.DSN == "" && (c.Postgres.Host == "" || c.Postgres.User == "" || c.Postgres.Database == "") {
+		errs = append(errs, errors.New("postgres.dsn (POSTGRES_DSN) or postgres host, user and database are required"))
+	}
+	if c.Postgres.MaxConns <= 0 {
+		errs = append(errs, errors.New("postgres.max_conns (POSTGRES_MAX_CONNS) must be positive"))
+	}
+	if c.Postgres.MinConns < 0 || c.Postgres.MinConns > c.Postgres.MaxConns {
+		errs = append(errs, errors.New("postgres.min_conns (POSTGRES_MIN_CONNS) must be between 0 and max_conns"))
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.shutdown_timeout (SHUTDOWN_TIMEOUT)", c.Server.ShutdownTimeout},
+		{"matchmaking.hold_ttl (MATCHMAKING_HOLD_TTL)", c.Matchmaking.HoldTTL},
+		{"matchmaking.hold_reaper_interval (MATCHMAKING_HOLD_REAPER_INTERVAL)", c.Matchmaking.HoldReaperInterval},
+		{"matchmaking.max_wait (MATCHMAKING_MAX_WAIT)", c.Matchmaking.MaxWait},
+		{"matchmaking.sweeper_interval (MATCHMAKING_SWEEPER_INTERVAL)", c.Matchmaking.SweeperInterval},
+		{"matchmaking.level_widen_interval (MATCHMAKING_LEVEL_WIDEN_INTERVAL)", c.Matchmaking.LevelWidenInterval},
+		{"matchmaking.throughput_window (MATCHMAKING_THROUGHPUT_WINDOW)", c.Matchmaking.ThroughputWindow},
+		{"matchmaking.accept_timeout (MATCHMAKING_ACCEPT_TIMEOUT)", c.Matchmaking.AcceptTimeout},
+		{"websocket.ping_interval (WEBSOCKET_PING_INTERVAL)", c.WebSocket.PingInterval},
+		{"websocket.pong_timeout (WEBSOCKET_PONG_TIMEOUT)", c.WebSocket.PongTimeout},
+		{"websocket.write_timeout (WEBSOCKET_WRITE_TIMEOUT)", c.WebSocket.WriteTimeout},
+		{"websocket.handler_timeout (WEBSOCKET_HANDLER_TIMEOUT)", c.WebSocket.HandlerTimeout},
+		{"websocket.outbox_ttl (WEBSOCKET_OUTBOX_TTL)", c.WebSocket.OutboxTTL},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", d.name))
+		}
+	}
+	if c.Matchmaking.MaxWait > 0 && c.Matchmaking.MaxWait < c.Matchmaking.SweeperInterval {
+		errs = append(errs, errors.New("matchmaking.max_wait must not be shorter than matchmaking.sweeper_interval"))
+	}
+
+	if c.Matchmaking.AcceptTimeout > 0 && c.Matchmaking.AcceptTimeout >= c.Matchmaking.HoldTTL {
+		errs = append(errs, errors.New("matchmaking.accept_timeout must be shorter than matchmaking.hold_ttl"))
+	}
+
+	if c.WebSocket.PingInterval > 0 && c.WebSocket.PongTimeout <= c.WebSocket.PingInterval {
+		errs = append(errs, errors.New("websocket.pong_timeout must be longer than websocket.ping_interval"))
+	}
+	if c.WebSocket.HandlerTimeout > 0 && c.WebSocket.HandlerTimeout > c.WebSocket.PongTimeout/2 {
+		errs = append(errs, errors.New("websocket.handler_timeout must be at most half of websocket.pong_timeout"))
+	}
+	if c.WebSocket.MaxMessageSize <= 0 {
+		errs = append(errs, errors.New("websocket.max_message_size (WEBSOCKET_MAX_MESSAGE_SIZE) must be positive"))
+	}
+	if c.WebSocket.OutboxMaxLength <= 0 {
+		errs = append(errs, errors.New("websocket.outbox_max_length (WEBSOCKET_OUTBOX_MAX_LENGTH) must be positive"))
+	}
+	switch c.WebSocket.DuplicatePolicy {
+	case "replace", "reject", "multi":
+	default:
+		errs = append(errs, errors.New("websocket.duplicate_policy (WEBSOCKET_DUPLICATE_POLICY) must be one of replace, reject, multi"))
+	}
+
+	if c.Auth.HMACSecret == "" && !c.Auth.DevMode {
+		errs = append(errs, errors.New("auth.hmac_secret (AUTH_HMAC_SECRET) is required unless auth.dev_mode (AUTH_DEV_MODE) is enabled"))
+	}
+	if c.Auth.HMACSecret != "" && len(c.Auth.HMACSecret) < 32 {
+		errs = append(errs, errors.New("auth.hmac_secret (AUTH_HMAC_SECRET) must be at least 32 bytes"))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+func setString(target *string, key string) {
+	if value, ok := os.LookupEnv(key); ok {
+		*target = value
+	}
+}
+
+func setInt(target *int, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer, got '%s'", key, value)
+	}
+	*target = parsed
+	return nil
+}
+
+func setInt32(target *int32, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return fmt.Errorf("%s must be an integer, got '%s'", key, value)
+	}
+	*target = int32(parsed)
+	return nil
+}
+
+func setBool(target *bool, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a boolean, got '%s'", key, value)
+	}
+	*target = parsed
+	return nil
+}
+
+func setDuration(target *time.Duration, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a duration such as '30s', got '%s'", key, value)
+	}
+	*target = parsed
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
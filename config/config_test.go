@@ -0,0 +1,122 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{
+			name:   "defaults with secret",
+			modify: func(c *Config) {},
+		},
+		{
+			name:   "dev mode without secret",
+			modify: func(c *Config) { c.Auth.HMACSecret = ""; c.Auth.DevMode = true },
+		},
+		{
+			name:    "missing secret",
+			modify:  func(c *Config) { c.Auth.HMACSecret = "" },
+			wantErr: "auth.hmac_secret (AUTH_HMAC_SECRET) is required",
+		},
+		{
+			name:    "short secret",
+			modify:  func(c *Config) { c.Auth.HMACSecret = "too-short" },
+			wantErr: "must be at least 32 bytes",
+		},
+		{
+			name:    "empty listen address",
+			modify:  func(c *Config) { c.Server.ListenAddr = "" },
+			wantErr: "server.listen_addr (LISTEN_ADDR) must not be empty",
+		},
+		{
+			name:    "no redis address",
+			modify:  func(c *Config) { c.Redis.Addr = "" },
+			wantErr: "redis.addr (REDIS_ADDR) or redis.url (REDIS_URL) is required",
+		},
+		{
+			name:   "redis url instead of address",
+			modify: func(c *Config) { c.Redis.Addr = ""; c.Redis.URL = "redis://localhost:6379/0" },
+		},
+		{
+			name:    "postgres min conns above max",
+			modify:  func(c *Config) { c.Postgres.MinConns = c.Postgres.MaxConns + 1 },
+			wantErr: "postgres.min_conns (POSTGRES_MIN_CONNS) must be between 0 and max_conns",
+		},
+		{
+			name:    "zero duration",
+			modify:  func(c *Config) { c.Matchmaking.HoldTTL = 0 },
+			wantErr: "matchmaking.hold_ttl (MATCHMAKING_HOLD_TTL) must be positive",
+		},
+		{
+			name:    "max wait shorter than sweeper interval",
+			modify:  func(c *Config) { c.Matchmaking.MaxWait = c.Matchmaking.SweeperInterval / 2 },
+			wantErr: "matchmaking.max_wait must not be shorter than matchmaking.sweeper_interval",
+		},
+		{
+			name:    "accept timeout as long as hold ttl",
+			modify:  func(c *Config) { c.Matchmaking.AcceptTimeout = c.Matchmaking.HoldTTL },
+			wantErr: "matchmaking.accept_timeout must be shorter than matchmaking.hold_ttl",
+		},
+		{
+			name:    "pong timeout not longer than ping interval",
+			modify:  func(c *Config) { c.WebSocket.PongTimeout = c.WebSocket.PingInterval },
+			wantErr: "websocket.pong_timeout must be longer than websocket.ping_interval",
+		},
+		{
+			name:   "handler timeout at half of pong timeout",
+			modify: func(c *Config) { c.WebSocket.HandlerTimeout = 30 * time.Second },
+		},
+		{
+			name:    "handler timeout above half of pong timeout",
+			modify:  func(c *Config) { c.WebSocket.HandlerTimeout = 31 * time.Second },
+			wantErr: "websocket.handler_timeout must be at most half of websocket.pong_timeout",
+		},
+		{
+			name:    "unknown duplicate policy",
+			modify:  func(c *Config) { c.WebSocket.DuplicatePolicy = "keep" },
+			wantErr: "websocket.duplicate_policy (WEBSOCKET_DUPLICATE_POLICY) must be one of replace, reject, multi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaults()
+			cfg.Auth.HMACSecret = strings.Repeat("s", 32)
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	cfg := defaults()
+	cfg.Server.ListenAddr = ""
+	cfg.WebSocket.MaxMessageSize = 0
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() = nil, want errors")
+	}
+	for _, want := range []string{"server.listen_addr", "websocket.max_message_size", "auth.hmac_secret"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want it to mention %s", err, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package feedback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"langapp-backend/storage/postgres"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var ErrFeedbackExists = errors.New("feedback already submitted for this session")
+
+const (
+	MinRating = 1
+	MaxRating = 5
+)
+
+// Tags are the predefined remarks a participant can attach to a rating
+var Tags = []string{
+	"patient",
+	"good corrections",
+	"friendly",
+	"clear speaker",
+	"engaging",
+	"well prepared",
+	"late",
+	"distracted",
+	"rude",
+}
+
+// IsValidTag reports whether tag is one of the predefined tags
+func IsValidTag(tag string) bool {
+	for _, t := range Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type Feedback struct {
+	ID         uuid.UUID `json:"id"`
+	SessionID  uuid.UUID `json:"session_id"`
+	FromUserID string    `json:"from_user_id"`
+	ToUserID   string    `json:"to_user_id"`
+	Rating     int       `json:"rating"`
+	Tags       []string  `json:"tags"`
+	Comment    string    `json:"comment,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Reputation aggregates the feedback a user has received
+type Reputation struct {
+	UserID        string     `json:"user_id"`
+	RatingCount   int        `json:"rating_count"`
+	AverageRating float64    `json:"average_rating"`
+	Tags          []TagCount `json:"tags"`
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+type Repository struct {
+	db *postgres.PostgresClient
+}
+
+func NewRepository(db *postgres.PostgresClient) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+// CreateFeedback stores feedback, returning ErrFeedbackExists if its author already rated
+// that session
+func (r *Repository) CreateFeedback(ctx context.Context, feedback *Feedback) error {
+	query := `
+		INSERT INTO session_feedback (session_id, from_user_id, to_user_id, rating, tags, comment)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	err := r.db.QueryRow(ctx, query,
+		feedback.SessionID, feedback.FromUserID, feedback.ToUserID, feedback.Rating, feedback.Tags, feedback.Comment,
+	).Scan(&feedback.ID, &feedback.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrFeedbackExists
+		}
+		return fmt.Errorf("error querying database: %v", err)
+	}
+
+	return nil
+}
+
+// GetReputation returns the aggregated feedback received by a user
+func (r *Repository) GetReputation(ctx context.Context, userID string) (*Reputation, error) {
+	reputations, err := r.GetReputations(ctx, []string{userID})
+	if err != nil {
+		return nil, err
+	}
+	return reputations[userID], nil
+}
+
+// GetReputations returns the aggregated feedback received by each of the given users, so that
+// matchmaking can compare its candidates in one query. Users without feedback get an empty
+// reputation.
+func (r *Repository) GetReputations(ctx context.Context, userIDs []string) (map[string]*Reputation, error) {
+	reputations := make(map[string]*Reputation, len(userIDs))
+	for _, userID := range userIDs {
+		reputations[userID] = &Reputation{UserID: userID, Tags: []TagCount{}}
+	}
+
+	ratingsQuery := `
+		SELECT to_user_id, COUNT(*), ROUND(AVG(rating), 2)::FLOAT8
+		FROM session_feedback
+		WHERE to_user_id = ANY($1)
+		GROUP BY to_user_id`
+
+	rows, err := r.db.Query(ctx, ratingsQuery, userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			userID  string
+			count   int
+			average float64
+		)
+		if err := rows.Scan(&userID, &count, &average); err != nil {
+			return nil, fmt.Errorf("error scanning reputation: %v", err)
+		}
+		reputations[userID].RatingCount = count
+		reputations[userID].AverageRating = average
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+
+	tagsQuery := `
+		SELECT to_user_id, tag, COUNT(*) AS count
+		FROM session_feedback, UNNEST(tags) AS tag
+		WHERE to_user_id = ANY($1)
+		GROUP BY to_user_id, tag
+		ORDER BY to_user_id, count DESC, tag`
+
+	tagRows, err := r.db.Query(ctx, tagsQuery, userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer tagRows.Close()
+
+	for tagRows.Next() {
+		var (
+			userID string
+			tag    TagCount
+		)
+		if err := tagRows.Scan(&userID, &tag.Tag, &tag.Count); err != nil {
+			return nil, fmt.Errorf("error scanning reputation tags: %v", err)
+		}
+		reputations[userID].Tags = append(reputations[userID].Tags, tag)
+	}
+	if err := tagRows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+
+	return reputations, nil
+}
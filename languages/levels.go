@@ -0,0 +1,37 @@
+package languages
+
+import "strings"
+
+// Level is a CEFR proficiency level, from A1 (beginner) to C2 (mastery)
+type Level string
+
+const (
+	LevelA1 Level = "A1"
+	LevelA2 Level = "A2"
+	LevelB1 Level = "B1"
+	LevelB2 Level = "B2"
+	LevelC1 Level = "C1"
+	LevelC2 Level = "C2"
+)
+
+var levels = []Level{LevelA1, LevelA2, LevelB1, LevelB2, LevelC1, LevelC2}
+
+// ParseLevel parses a CEFR level case-insensitively
+func ParseLevel(s string) (Level, bool) {
+	level := Level(strings.ToUpper(strings.TrimSpace(s)))
+	return level, level.IsValid()
+}
+
+func (l Level) IsValid() bool {
+	return l.Rank() >= 0
+}
+
+// Rank returns the position of the level from 0 (A1) to 5 (C2), or -1 for an invalid level
+func (l Level) Rank() int {
+	for i, level := range levels {
+		if level == l {
+			return i
+		}
+	}
+	return -1
+}
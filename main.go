@@ -2,25 +2,55 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"langapp-backend/api"
+	"langapp-backend/auth"
+	"langapp-backend/blocks"
+	"langapp-backend/config"
+	"langapp-backend/feedback"
+	"langapp-backend/health"
 	"langapp-backend/languages"
 	"langapp-backend/matchmaking"
+	"langapp-backend/moderation"
 	"langapp-backend/session"
+	"langapp-backend/signaling"
 	"langapp-backend/storage/postgres"
 	"langapp-backend/storage/redis"
+	"langapp-backend/users"
 	"langapp-backend/websocket"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
-	ctx := context.Background()
+	// ctx is cancelled on SIGINT/SIGTERM, which stops every background worker
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
 
-	redisClient := redis.NewRedisClient()
+	redisClient, err := redis.NewRedisClient(cfg.Redis)
+	if err != nil {
+		log.Fatalf("Failed to create redis client: %v", err)
+	}
 	pubSubManager := redis.NewPubSubManager(redisClient)
 
-	postgresClient := postgres.NewPostgresClient(ctx)
+	postgresClient, err := postgres.NewPostgresClient(ctx, cfg.Postgres)
+	if err != nil {
+		log.Fatalf("Failed to connect to postgres: %v", err)
+	}
 	defer postgresClient.Close()
 
 	// Run database migrations
@@ -29,6 +59,10 @@ func main() {
 	}
 
 	sessionRepository := session.NewRepository(postgresClient)
+	usersRepository := users.NewRepository(postgresClient)
+	feedbackRepository := feedback.NewRepository(postgresClient)
+	moderationRepository := moderation.NewRepository(postgresClient)
+	blockService := blocks.NewBlockService(blocks.NewRepository(postgresClient), redisClient)
 
 	languagesRepository := languages.NewRepository(postgresClient)
 	languages, err := languagesRepository.GetAllLanguages(ctx)
@@ -45,18 +79,92 @@ func main() {
 		log.Fatalf("Failed to initialize language publishers: %v", err)
 	}
 
-	wsManager := websocket.NewManager()
+	nodeID := cfg.Server.NodeID
+	if nodeID == "" {
+		nodeID = uuid.NewString()
+	}
+	outbox := websocket.NewOutbox(redisClient, cfg.WebSocket.OutboxTTL, cfg.WebSocket.OutboxMaxLength)
+	wsManager := websocket.NewManager(nodeID, pubSubManager, outbox, moderationRepository, cfg.WebSocket)
 	go wsManager.Start()
+	go wsManager.StartRelay(ctx)
 
-	matchmakingService := matchmaking.NewMatchmakingService(redisClient, pubSubManager, wsManager, sessionRepository, languageNames)
+	matchmakingService := matchmaking.NewMatchmakingService(redisClient, pubSubManager, wsManager, sessionRepository, blockService, feedbackRepository, languageNames, cfg.Matchmaking)
 	if err := matchmakingService.InitializeLanguageChannels(ctx, languageNames); err != nil {
 		log.Fatalf("Failed to initialize language channels: %v", err)
 	}
-	go matchmakingService.Start(ctx)
+	matchmakingService.RegisterHandlers()
+	matchmakingService.Start(ctx)
+	go matchmakingService.RunSweeper(ctx)
+	go matchmakingService.RunHoldReaper(ctx)
+	go matchmakingService.RunProposalExpirer(ctx)
+
+	prometheus.MustRegister(
+		matchmakingService.QueueCollector(),
+		wsManager.ClientsCollector(),
+		postgresClient.PoolCollector(),
+	)
+
+	signalingService := signaling.NewSignalingService(sessionRepository, wsManager)
+	signalingService.RegisterHandlers()
+
+	lifecycleService := session.NewLifecycleService(sessionRepository, wsManager)
+	lifecycleService.RegisterHandlers()
+
+	var authenticator auth.Authenticator
+	if cfg.Auth.HMACSecret != "" {
+		authenticator = auth.NewTokenAuthenticator([]byte(cfg.Auth.HMACSecret), cfg.Auth.Issuer)
+	} else {
+		authenticator = auth.NewDevAuthenticator()
+	}
 
-	apiService := api.NewAPIService(matchmakingService, languagesRepository, wsManager)
-	r := api.NewRouter(apiService)
+	healthChecker := health.NewChecker(2*time.Second,
+		health.Check{Name: "redis", Run: func(ctx context.Context) error { return redisClient.Ping(ctx).Err() }},
+		health.Check{Name: "postgres", Run: postgresClient.Ping},
+		health.Check{Name: "migrations", Run: postgresClient.CheckMigrations},
+		health.Check{Name: "matchmaking_listeners", Run: func(ctx context.Context) error { return matchmakingService.CheckListeners() }},
+	)
+
+	apiService := api.NewAPIService(matchmakingService, languagesRepository, usersRepository, sessionRepository, feedbackRepository, blockService, moderationRepository, wsManager)
+	r := api.NewRouter(apiService, healthChecker, authenticator)
+
+	server := &http.Server{
+		Addr:    cfg.Server.ListenAddr,
+		Handler: r,
+	}
+
+	go func() {
+		log.Printf("Server starting on %s with %d language channels initialized", cfg.Server.ListenAddr, len(languageNames))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutdown signal received, draining")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Stop accepting queue joins; cancelling ctx has already stopped the language listeners
+	// from taking new messages, so wait for in-flight matches to finish. A hung match may use
+	// at most half of the shutdown timeout, leaving the rest to close connections.
+	matchmakingService.Drain()
+	waitCtx, cancelWait := context.WithTimeout(shutdownCtx, cfg.Server.ShutdownTimeout/2)
+	if busy := matchmakingService.Wait(waitCtx); len(busy) > 0 {
+		log.Printf("Abandoning matchmaking listeners still busy for: %s", strings.Join(busy, ", "))
+	}
+	cancelWait()
+
+	if cfg.Matchmaking.RequeueHeldOnShutdown {
+		matchmakingService.RestoreHeldUsers(shutdownCtx)
+	}
+
+	wsManager.Shutdown(shutdownCtx, "server restarting")
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown did not complete: %v", err)
+	}
 
-	log.Printf("Server starting on :8080 with %d language channels initialized", len(languageNames))
-	log.Fatal(http.ListenAndServe(":8080", r))
+	log.Println("Server stopped")
 }
@@ -0,0 +1,56 @@
+package matchmaking
+
+import (
+	"time"
+
+	"langapp-backend/languages"
+)
+
+// MatchPreferences are the partner requirements a user joins the queue with
+type MatchPreferences struct {
+	MatchMode MatchMode `json:"match_mode"`
+	// Levels holds the user's CEFR level in each practice language; missing when unknown
+	Levels map[string]languages.Level `json:"levels,omitempty"`
+	// PartnerLevelMin and PartnerLevelMax bound the level a partner should have in the
+	// language they practice with this user. Empty bounds are open.
+	PartnerLevelMin languages.Level `json:"partner_level_min,omitempty"`
+	PartnerLevelMax languages.Level `json:"partner_level_max,omitempty"`
+}
+
+// hasLevelRange reports whether the user restricted the level of their partner
+func (p MatchPreferences) hasLevelRange() bool {
+	return p.PartnerLevelMin != "" || p.PartnerLevelMax != ""
+}
+
+// acceptedLevels returns the ranks of the partner levels the entry accepts after waiting for
+// waited. The range grows by one level on each side for every widenInterval waited.
+func (e QueueEntry) acceptedLevels(waited, widenInterval time.Duration) (int, int) {
+	minRank, maxRank := 0, languages.LevelC2.Rank()
+	if e.PartnerLevelMin != "" {
+		minRank = e.PartnerLevelMin.Rank()
+	}
+	if e.PartnerLevelMax != "" {
+		maxRank = e.PartnerLevelMax.Rank()
+	}
+
+	widening := levelWidening(waited, widenInterval)
+	return minRank - widening, maxRank + widening
+}
+
+// acceptsLevel reports whether a partner practicing at level suits the entry. Partners of
+// unknown level are always accepted.
+func (e QueueEntry) acceptsLevel(level languages.Level, now time.Time, widenInterval time.Duration) bool {
+	if level == "" || !e.hasLevelRange() {
+		return true
+	}
+	minRank, maxRank := e.acceptedLevels(now.Sub(e.Timestamp), widenInterval)
+	rank := level.Rank()
+	return rank >= minRank && rank <= maxRank
+}
+
+func levelWidening(waited, widenInterval time.Duration) int {
+	if waited <= 0 || widenInterval <= 0 {
+		return 0
+	}
+	return int(waited / widenInterval)
+}
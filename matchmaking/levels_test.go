@@ -0,0 +1,189 @@
+package matchmaking
+
+import (
+	"testing"
+	"time"
+
+	"langapp-backend/languages"
+)
+
+func TestLevelWidening(t *testing.T) {
+	tests := []struct {
+		name          string
+		waited        time.Duration
+		widenInterval time.Duration
+		want          int
+	}{
+		{"not waited", 0, 30 * time.Second, 0},
+		{"negative wait", -time.Minute, 30 * time.Second, 0},
+		{"widening disabled", time.Hour, 0, 0},
+		{"before the first interval", 29 * time.Second, 30 * time.Second, 0},
+		{"at the first interval", 30 * time.Second, 30 * time.Second, 1},
+		{"several intervals", 95 * time.Second, 30 * time.Second, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := levelWidening(tt.waited, tt.widenInterval); got != tt.want {
+				t.Fatalf("levelWidening(%s, %s) = %d, want %d", tt.waited, tt.widenInterval, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcceptedLevels(t *testing.T) {
+	const widenInterval = 30 * time.Second
+
+	tests := []struct {
+		name     string
+		min, max languages.Level
+		waited   time.Duration
+		wantMin  int
+		wantMax  int
+	}{
+		{"open range", "", "", 0, 0, 5},
+		{"bounded range", languages.LevelB1, languages.LevelB2, 0, 2, 3},
+		{"only a minimum", languages.LevelB2, "", 0, 3, 5},
+		{"only a maximum", "", languages.LevelA2, 0, 0, 1},
+		{"widened once", languages.LevelB1, languages.LevelB2, 30 * time.Second, 1, 4},
+		{"widened past the ends", languages.LevelB1, languages.LevelB2, 3 * widenInterval, -1, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := QueueEntry{MatchPreferences: MatchPreferences{PartnerLevelMin: tt.min, PartnerLevelMax: tt.max}}
+			gotMin, gotMax := entry.acceptedLevels(tt.waited, widenInterval)
+			if gotMin != tt.wantMin || gotMax != tt.wantMax {
+				t.Fatalf("acceptedLevels() = (%d, %d), want (%d, %d)", gotMin, gotMax, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestAcceptsLevel(t *testing.T) {
+	const widenInterval = 30 * time.Second
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		min    languages.Level
+		max    languages.Level
+		waited time.Duration
+		level  languages.Level
+		want   bool
+	}{
+		{"unknown partner level", languages.LevelC1, languages.LevelC2, 0, "", true},
+		{"no range", "", "", 0, languages.LevelA1, true},
+		{"inside the range", languages.LevelB1, languages.LevelB2, 0, languages.LevelB2, true},
+		{"below the range", languages.LevelB1, languages.LevelB2, 0, languages.LevelA2, false},
+		{"below the range until widened", languages.LevelB1, languages.LevelB2, widenInterval, languages.LevelA2, true},
+		{"above the range after widening once", languages.LevelB1, languages.LevelB2, widenInterval, languages.LevelC2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := QueueEntry{
+				Timestamp:        now.Add(-tt.waited),
+				MatchPreferences: MatchPreferences{PartnerLevelMin: tt.min, PartnerLevelMax: tt.max},
+			}
+			if got := entry.acceptsLevel(tt.level, now, widenInterval); got != tt.want {
+				t.Fatalf("acceptsLevel(%q) = %v, want %v", tt.level, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScoreCandidate(t *testing.T) {
+	const widenInterval = 30 * time.Second
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// newEntry is a native English speaker practicing Spanish, scored against learners of English
+	newEntry := func(mode MatchMode, min, max languages.Level) QueueEntry {
+		return QueueEntry{
+			UserID:            "new",
+			NativeLanguages:   []string{"English"},
+			PracticeLanguages: []string{"Spanish"},
+			Timestamp:         now,
+			MatchPreferences: MatchPreferences{
+				MatchMode:       mode,
+				Levels:          map[string]languages.Level{"Spanish": languages.LevelB1},
+				PartnerLevelMin: min,
+				PartnerLevelMax: max,
+			},
+		}
+	}
+	learner := func(native string, level languages.Level, min, max languages.Level) QueueEntry {
+		return QueueEntry{
+			UserID:            "learner",
+			NativeLanguages:   []string{native},
+			PracticeLanguages: []string{"English"},
+			Timestamp:         now,
+			MatchPreferences: MatchPreferences{
+				MatchMode:       MatchModeReciprocal,
+				Levels:          map[string]languages.Level{"English": level},
+				PartnerLevelMin: min,
+				PartnerLevelMax: max,
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		newEntry QueueEntry
+		learner  QueueEntry
+		want     int
+	}{
+		{
+			name:     "reciprocal partner",
+			newEntry: newEntry(MatchModeReciprocal, "", ""),
+			learner:  learner("Spanish", languages.LevelA2, "", ""),
+			want:     2,
+		},
+		{
+			name:     "one-way partner in reciprocal mode",
+			newEntry: newEntry(MatchModeReciprocal, "", ""),
+			learner:  learner("French", languages.LevelA2, "", ""),
+			want:     0,
+		},
+		{
+			name:     "one-way partner in tutor mode",
+			newEntry: newEntry(MatchModeTutor, "", ""),
+			learner:  learner("French", languages.LevelA2, "", ""),
+			want:     1,
+		},
+		{
+			name:     "learner level outside the accepted range",
+			newEntry: newEntry(MatchModeReciprocal, languages.LevelC1, ""),
+			learner:  learner("Spanish", languages.LevelA2, "", ""),
+			want:     0,
+		},
+		{
+			name:     "learner does not accept the new user's level",
+			newEntry: newEntry(MatchModeReciprocal, "", ""),
+			learner:  learner("Spanish", languages.LevelA2, languages.LevelC1, ""),
+			want:     0,
+		},
+		{
+			name:     "learner does not accept the new user's level in tutor mode",
+			newEntry: newEntry(MatchModeTutor, "", ""),
+			learner:  learner("Spanish", languages.LevelA2, languages.LevelC1, ""),
+			want:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scoreCandidate(tt.newEntry, "English", tt.learner, now, widenInterval); got != tt.want {
+				t.Fatalf("scoreCandidate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("range widens while waiting", func(t *testing.T) {
+		waiting := newEntry(MatchModeReciprocal, languages.LevelB1, "")
+		waiting.Timestamp = now.Add(-2 * widenInterval)
+		if got := scoreCandidate(waiting, "English", learner("Spanish", languages.LevelA1, "", ""), now, widenInterval); got != 2 {
+			t.Fatalf("scoreCandidate() = %d, want 2", got)
+		}
+	})
+}
@@ -11,125 +11,115 @@ import (
 )
 
 const (
-	holdSetKeyPrefix  = "hold:"
-	holdDataKeyPrefix = "hold:data:"
-	holdTTL           = 30 * time.Second // TTL for hold states to prevent stuck users
-)
+	holdSetKeyPrefix  = "hold:"      // Sorted set of held user IDs scored by the time they were put on hold
+	holdDataKeyPrefix = "hold:data:" // Copy of the held user's queue entry
 
-// putUserOnHold atomically moves a user from the queue to hold state
-func (ms *MatchmakingService) putUserOnHold(ctx context.Context, userID, language string) (*QueueEntry, error) {
-	queueKey := "queue:" + language
-	holdSetKey := holdSetKeyPrefix + language
-	holdDataKey := holdDataKeyPrefix + userID
+	// Hold data outlives the hold TTL by this factor so the reaper can still restore it
+	holdDataTTLFactor = 10
+)
 
-	// First, try to pop the user from the queue
-	userIDFromQueue, err := ms.redisClient.LPop(ctx, queueKey).Result()
-	if err != nil {
-		if err == redis.Nil {
-			log.Printf("No user in queue on pop, %s", userID)
-			return nil, nil // No user in queue
-		}
-		return nil, fmt.Errorf("failed to pop from queue '%s': %w", queueKey, err)
+// putUserOnHold atomically moves a queued user from all of their queues to hold state. It
+// returns nil if the entry is no longer queued, e.g. because another match claimed the user first.
+func (ms *MatchmakingService) putUserOnHold(ctx context.Context, entry QueueEntry) (*QueueEntry, error) {
+	language := entry.holdLanguage()
+	keys := append([]string{
+		usersDataHashKey,
+		holdSetKeyPrefix + language,
+		holdDataKeyPrefix + entry.UserID,
+	}, entry.listKeys()...)
+	args := []interface{}{
+		entry.UserID,
+		entry.Timestamp.Format(time.RFC3339Nano),
+		time.Now().Unix(),
+		int((holdDataTTLFactor * ms.config.HoldTTL).Seconds()),
 	}
 
-	// Verify this is the expected user (prevent race conditions)
-	if userIDFromQueue != userID {
-		// Put the user back at the front of the queue if it's not the expected user
-		if pushErr := ms.redisClient.LPush(ctx, queueKey, userIDFromQueue).Err(); pushErr != nil {
-			// Log error but don't return it as the main operation failed
-			fmt.Printf("Warning: failed to restore user '%s' to queue after mismatch: %v", userIDFromQueue, pushErr)
-		}
-		return nil, fmt.Errorf("queue race condition detected: expected user '%s', got '%s'", userID, userIDFromQueue)
-	}
-
-	// Get user data from the main hash
-	entryJSON, err := ms.redisClient.HGet(ctx, usersDataHashKey, userID).Result()
+	entryJSON, err := holdScript.Run(ctx, ms.redisClient, keys, args...).Text()
 	if err != nil {
-		// Restore user to queue since we couldn't get their data
-		if pushErr := ms.redisClient.LPush(ctx, queueKey, userID).Err(); pushErr != nil {
-			fmt.Printf("Warning: failed to restore user '%s' to queue after data fetch error: %v", userID, pushErr)
+		if err == redis.Nil {
+			log.Printf("User %s no longer queued, already claimed", entry.UserID)
+			holdConflicts.Inc()
+			return nil, nil
 		}
-		return nil, fmt.Errorf("could not find data for user '%s': %w", userID, err)
+		return nil, fmt.Errorf("failed to put user '%s' on hold: %w", entry.UserID, err)
 	}
 
-	// Parse the user data
-	var entry QueueEntry
-	if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+	var held QueueEntry
+	if err := json.Unmarshal([]byte(entryJSON), &held); err != nil {
 		// Restore user to queue since we couldn't parse their data
-		if pushErr := ms.redisClient.LPush(ctx, queueKey, userID).Err(); pushErr != nil {
-			fmt.Printf("Warning: failed to restore user '%s' to queue after parse error: %v", userID, pushErr)
-		}
-		return nil, fmt.Errorf("failed to unmarshal data for user '%s': %w", userID, err)
-	}
-
-	// Atomically put user in hold state with TTL
-	pipe := ms.redisClient.Pipeline()
-	pipe.SAdd(ctx, holdSetKey, userID)
-	pipe.Expire(ctx, holdSetKey, holdTTL)
-	pipe.HSet(ctx, holdDataKey, "data", entryJSON)
-	pipe.Expire(ctx, holdDataKey, holdTTL)
-	_, err = pipe.Exec(ctx)
-	if err != nil {
-		// Restore user to queue since hold operation failed
-		if pushErr := ms.redisClient.LPush(ctx, queueKey, userID).Err(); pushErr != nil {
-			fmt.Printf("Warning: failed to restore user '%s' to queue after hold operation failure: %v", userID, pushErr)
+		if restoreErr := ms.restoreUserFromHold(ctx, entry.UserID, language); restoreErr != nil {
+			log.Printf("Warning: failed to restore user '%s' to queue after parse error: %v", entry.UserID, restoreErr)
 		}
-		return nil, fmt.Errorf("failed to put user '%s' on hold: %w", userID, err)
+		return nil, fmt.Errorf("failed to unmarshal data for user '%s': %w", entry.UserID, err)
 	}
 
-	return &entry, nil
+	ms.trackHold(entry.UserID, language)
+	holdOperations.WithLabelValues(holdOperationHold).Inc()
+	return &held, nil
 }
 
 // releaseUserFromHold removes a user from hold state after successful matching
 func (ms *MatchmakingService) releaseUserFromHold(ctx context.Context, userID, language string) error {
-	holdSetKey := holdSetKeyPrefix + language
-	holdDataKey := holdDataKeyPrefix + userID
+	keys := []string{
+		holdSetKeyPrefix + language,
+		holdDataKeyPrefix + userID,
+		usersDataHashKey,
+	}
 
-	// Atomically remove user from hold state and main user data
-	pipe := ms.redisClient.Pipeline()
-	pipe.SRem(ctx, holdSetKey, userID)
-	pipe.Del(ctx, holdDataKey)
-	pipe.HDel(ctx, usersDataHashKey, userID)
-	_, err := pipe.Exec(ctx)
-	if err != nil {
+	if err := releaseScript.Run(ctx, ms.redisClient, keys, userID).Err(); err != nil {
 		return fmt.Errorf("failed to release user '%s' from hold: %w", userID, err)
 	}
 
+	ms.untrackHold(userID)
+	holdOperations.WithLabelValues(holdOperationRelease).Inc()
 	return nil
 }
 
-// restoreUserFromHold moves a user back from hold state to the queue
+// restoreUserFromHold moves a user back from hold state to the front of their queues. If the
+// hold data has expired, the user's entry in the main hash is used instead.
 func (ms *MatchmakingService) restoreUserFromHold(ctx context.Context, userID, language string) error {
-	holdSetKey := holdSetKeyPrefix + language
 	holdDataKey := holdDataKeyPrefix + userID
-	queueKey := "queue:" + language
 
-	// Get user data from hold
+	// Read the entry to find the user's lists; the script reads it again atomically
 	entryJSON, err := ms.redisClient.HGet(ctx, holdDataKey, "data").Result()
-	if err != nil {
-		if err == redis.Nil {
-			// User not in hold, try to clean up from hold set anyway
-			ms.redisClient.SRem(ctx, holdSetKey, userID)
-			return nil
-		}
+	if err == redis.Nil {
+		entryJSON, err = ms.redisClient.HGet(ctx, usersDataHashKey, userID).Result()
+	}
+	if err != nil && err != redis.Nil {
 		return fmt.Errorf("could not find hold data for user '%s': %w", userID, err)
 	}
 
-	// Parse the user data to validate
 	var entry QueueEntry
-	if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
-		return fmt.Errorf("failed to unmarshal hold data for user '%s': %w", userID, err)
+	if entryJSON != "" {
+		if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+			return fmt.Errorf("failed to unmarshal hold data for user '%s': %w", userID, err)
+		}
 	}
 
-	// Atomically restore user to queue and remove from hold
-	pipe := ms.redisClient.Pipeline()
-	pipe.RPush(ctx, queueKey, userID) // Put back at end of queue
-	pipe.SRem(ctx, holdSetKey, userID)
-	pipe.Del(ctx, holdDataKey)
-	_, err = pipe.Exec(ctx)
-	if err != nil {
+	keys := append([]string{
+		usersDataHashKey,
+		holdSetKeyPrefix + language,
+		holdDataKey,
+	}, entry.listKeys()...)
+
+	if err := restoreScript.Run(ctx, ms.redisClient, keys, userID).Err(); err != nil {
 		return fmt.Errorf("failed to restore user '%s' from hold to queue: %w", userID, err)
 	}
 
+	ms.untrackHold(userID)
+	holdOperations.WithLabelValues(holdOperationRestore).Inc()
 	return nil
 }
+
+// trackHold remembers a user this instance put on hold, so they can be restored on shutdown
+func (ms *MatchmakingService) trackHold(userID, language string) {
+	ms.heldMutex.Lock()
+	defer ms.heldMutex.Unlock()
+	ms.heldUsers[userID] = language
+}
+
+func (ms *MatchmakingService) untrackHold(userID string) {
+	ms.heldMutex.Lock()
+	defer ms.heldMutex.Unlock()
+	delete(ms.heldUsers, userID)
+}
@@ -3,70 +3,138 @@ package matchmaking
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"langapp-backend/config"
+	"langapp-backend/feedback"
 	"langapp-backend/session"
 	"langapp-backend/websocket"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
 type SessionRepository interface {
 	CreateSession(ctx context.Context, practiceUserID, nativeUserID, language string) (*session.Session, error)
+	UpdateSession(ctx context.Context, sessionID uuid.UUID, from, to session.SessionStatus) (*session.Session, error)
 	GetSessionByUserID(ctx context.Context, userID string) (*session.Session, error)
 }
 
+// BlockChecker reports whether either of two users has blocked the other
+type BlockChecker interface {
+	IsBlocked(ctx context.Context, userID, otherUserID string) (bool, error)
+}
+
+// ReputationSource returns the aggregated feedback that users received from past partners
+type ReputationSource interface {
+	GetReputations(ctx context.Context, userIDs []string) (map[string]*feedback.Reputation, error)
+}
+
 type MatchmakingService struct {
 	redisClient       RedisClient
 	pubSubManager     PubSubManager
 	wsManager         *websocket.Manager
 	sessionRepository SessionRepository
+	blockChecker      BlockChecker
+	reputations       ReputationSource
 	languages         []string
+	config            config.MatchmakingConfig
+	reaperSuspects    map[string]bool // Inconsistencies seen on the previous reconcile pass
+	draining          atomic.Bool     // Set on shutdown to stop accepting queue joins
+	listeners         sync.WaitGroup
+	heldUsers         map[string]string // Users this instance holds for a match, mapped to their queue language
+	heldMutex         sync.Mutex
+	subscribed        map[string]bool // Languages whose channel subscription Redis has confirmed
+	subscribedMutex   sync.Mutex
+	listening         map[string]bool // Languages whose listener has not returned yet
+	listeningMutex    sync.Mutex
 }
 
 type MatchNotification struct {
+	SessionID string `json:"session_id"`
 	PartnerID string `json:"partner_id"`
 	Language  string `json:"language"`
 	Message   string `json:"message"`
 }
 
-func NewMatchmakingService(redisClient RedisClient, pubSubManager PubSubManager, wsManager *websocket.Manager, sessionRepository SessionRepository, languages []string) *MatchmakingService {
+func NewMatchmakingService(redisClient RedisClient, pubSubManager PubSubManager, wsManager *websocket.Manager, sessionRepository SessionRepository, blockChecker BlockChecker, reputations ReputationSource, languages []string, cfg config.MatchmakingConfig) *MatchmakingService {
 	return &MatchmakingService{
 		redisClient:       redisClient,
 		pubSubManager:     pubSubManager,
 		wsManager:         wsManager,
 		sessionRepository: sessionRepository,
+		blockChecker:      blockChecker,
+		reputations:       reputations,
 		languages:         languages,
+		config:            cfg,
+		heldUsers:         make(map[string]string),
+		subscribed:        make(map[string]bool),
+		listening:         make(map[string]bool),
 	}
 }
 
+// Start subscribes to every language channel. The listeners stop when ctx is cancelled;
+// Wait blocks until they have finished.
 func (ms *MatchmakingService) Start(ctx context.Context) {
 	for _, language := range ms.languages {
+		ms.listeners.Add(1)
+		ms.setListening(language, true)
 		go ms.listenToLanguageChannel(ctx, language)
 	}
 	log.Printf("Matching service started for %d languages", len(ms.languages))
 }
 
 func (ms *MatchmakingService) listenToLanguageChannel(ctx context.Context, language string) {
+	defer ms.listeners.Done()
+	defer ms.setListening(language, false)
+
 	pubsub := ms.pubSubManager.SubscribeToLanguageChannel(ctx, language)
 	defer pubsub.Close()
+	defer ms.setSubscribed(language, false)
 
 	log.Printf("Listening to channel for language: %s", language)
 
-	ch := pubsub.Channel()
-	for msg := range ch {
+	// Subscription confirmations are delivered too, including after reconnects
+	ch := pubsub.ChannelWithSubscriptions()
+	for {
+		var msg *redis.Message
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped listening to channel for language: %s", language)
+			return
+		case received, ok := <-ch:
+			if !ok {
+				return
+			}
+			if subscription, ok := received.(*redis.Subscription); ok {
+				ms.setSubscribed(language, subscription.Kind == "subscribe")
+				continue
+			}
+			if msg, ok = received.(*redis.Message); !ok {
+				continue
+			}
+		}
+
 		var nativeEntry QueueEntry
 		err := json.Unmarshal([]byte(msg.Payload), &nativeEntry)
 		if err != nil {
 			log.Printf("Error unmarshaling message: %v", err)
 			continue
 		}
+		if !nativeEntry.hasLanguages() {
+			log.Printf("Ignoring entry without languages for user %s", nativeEntry.UserID)
+			continue
+		}
 
-		log.Printf("New user in %s channel: %s (native: %s, practice: %s)", language, nativeEntry.UserID, nativeEntry.NativeLanguage, nativeEntry.PracticeLanguage)
+		log.Printf("New user in %s channel: %s (native: %v, practice: %v)", language, nativeEntry.UserID, nativeEntry.NativeLanguages, nativeEntry.PracticeLanguages)
 
-		err = ms.processMessage(ctx, nativeEntry)
+		// A match in progress is finished even if shutdown starts meanwhile
+		err = ms.processMessage(context.WithoutCancel(ctx), nativeEntry, language)
 		if err != nil {
 			log.Printf("Error processing message: %v", err)
 			continue
@@ -74,37 +142,52 @@ func (ms *MatchmakingService) listenToLanguageChannel(ctx context.Context, langu
 	}
 }
 
-func (ms *MatchmakingService) processMessage(ctx context.Context, nativeEntry QueueEntry) error {
-	practiceEntry, err := ms.findMatch(ctx, nativeEntry)
+// match pairs a native speaker of a language with a user practicing it
+type match struct {
+	nativeEntry   QueueEntry
+	practiceEntry QueueEntry
+	language      string
+}
+
+// processMessage looks for a partner who practices language, one of newEntry's native languages
+func (ms *MatchmakingService) processMessage(ctx context.Context, newEntry QueueEntry, language string) error {
+	m, err := ms.findMatch(ctx, newEntry, language)
 	if err != nil {
 		log.Printf("Error finding match: %v", err)
 		return fmt.Errorf("error finding match: %v", err)
 	}
 
-	if practiceEntry != nil {
-		log.Printf("Match found! %s <-> %s practicing %s", nativeEntry.UserID, practiceEntry.UserID, nativeEntry.NativeLanguage)
-		err = ms.initializeSession(ctx, nativeEntry, *practiceEntry)
-		if err != nil {
-			// Restore the practice user back to the queue since session creation failed
-			if restoreErr := ms.restoreUserFromHold(ctx, practiceEntry.UserID, nativeEntry.NativeLanguage); restoreErr != nil {
-				log.Printf("Failed to restore user %s from hold after session creation failure: %v", practiceEntry.UserID, restoreErr)
+	if m == nil {
+		log.Printf("Match not found for user %s", newEntry.UserID)
+		return nil
+	}
+
+	log.Printf("Match found! %s <-> %s practicing %s", m.nativeEntry.UserID, m.practiceEntry.UserID, m.language)
+	err = ms.initializeSession(ctx, *m)
+	if err != nil {
+		// Restore both users back to their queues since session creation failed
+		for _, entry := range []QueueEntry{m.nativeEntry, m.practiceEntry} {
+			if restoreErr := ms.restoreUserFromHold(ctx, entry.UserID, entry.holdLanguage()); restoreErr != nil {
+				log.Printf("Failed to restore user %s from hold after session creation failure: %v", entry.UserID, restoreErr)
 			}
-			return fmt.Errorf("error initializing session after finding match: %v", err)
 		}
+		return fmt.Errorf("error initializing session after finding match: %v", err)
+	}
 
-		// Session created successfully, release the practice user from hold
-		if releaseErr := ms.releaseUserFromHold(ctx, practiceEntry.UserID, nativeEntry.NativeLanguage); releaseErr != nil {
-			log.Printf("Warning: failed to release user %s from hold after successful match: %v", practiceEntry.UserID, releaseErr)
-		}
+	// Both users stay on hold until the proposal is resolved, possibly by another instance, so
+	// this one no longer restores them on shutdown
+	for _, entry := range []QueueEntry{m.nativeEntry, m.practiceEntry} {
+		ms.untrackHold(entry.UserID)
 	}
 
-	log.Printf("Match not found for user %s", nativeEntry.UserID)
 	return nil
 }
 
-func (ms *MatchmakingService) initializeSession(ctx context.Context, nativeEntry, practiceEntry QueueEntry) error {
-	language := nativeEntry.NativeLanguage
-	session, err := ms.sessionRepository.CreateSession(
+// initializeSession creates a proposed session and proposes it to both users, who must accept
+// it before it goes ahead
+func (ms *MatchmakingService) initializeSession(ctx context.Context, m match) error {
+	nativeEntry, practiceEntry, language := m.nativeEntry, m.practiceEntry, m.language
+	sess, err := ms.sessionRepository.CreateSession(
 		ctx,
 		practiceEntry.UserID,
 		nativeEntry.UserID,
@@ -115,61 +198,215 @@ func (ms *MatchmakingService) initializeSession(ctx context.Context, nativeEntry
 		return err
 	}
 
-	log.Printf("Created session %s for match - Language: %s", session.ID.String(), language)
+	log.Printf("Created session %s for match - Language: %s", sess.ID.String(), language)
 
+	if err := ms.proposeMatch(ctx, m, sess.ID); err != nil {
+		if _, updateErr := ms.sessionRepository.UpdateSession(ctx, sess.ID, session.SessionProposed, session.SessionFailed); updateErr != nil {
+			log.Printf("Failed to mark unproposed session %s failed: %v", sess.ID, updateErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// notifyMatchFound tells both users that their session is ready, once they accepted it
+func (ms *MatchmakingService) notifyMatchFound(sessionID uuid.UUID, nativeUserID, practiceUserID, language string) {
 	practiceUserMessage := websocket.Message{
 		Type: websocket.MatchFound,
 		Data: MatchNotification{
-			PartnerID: nativeEntry.UserID,
+			SessionID: sessionID.String(),
+			PartnerID: nativeUserID,
 			Language:  language,
-			Message:   fmt.Sprintf("Match found! You'll practice %s with %s", language, nativeEntry.UserID),
+			Message:   fmt.Sprintf("Match found! You'll practice %s with %s", language, nativeUserID),
 		},
 	}
 
 	nativeUserMessage := websocket.Message{
 		Type: websocket.MatchFound,
 		Data: MatchNotification{
-			PartnerID: practiceEntry.UserID,
+			SessionID: sessionID.String(),
+			PartnerID: practiceUserID,
 			Language:  language,
-			Message:   fmt.Sprintf("Match found! You'll help %s practice %s", practiceEntry.UserID, language),
+			Message:   fmt.Sprintf("Match found! You'll help %s practice %s", practiceUserID, language),
 		},
 	}
 
-	if err := ms.wsManager.SendMessage(practiceEntry.UserID, practiceUserMessage); err != nil {
-		log.Printf("Failed to notify practice user %s: %v", practiceEntry.UserID, err)
+	if err := ms.wsManager.SendMessage(practiceUserID, practiceUserMessage); err != nil {
+		log.Printf("Failed to notify practice user %s: %v", practiceUserID, err)
 	}
 
-	if err := ms.wsManager.SendMessage(practiceEntry.UserID, nativeUserMessage); err != nil {
-		log.Printf("Failed to notify native user %s: %v", nativeEntry.UserID, err)
+	if err := ms.wsManager.SendMessage(nativeUserID, nativeUserMessage); err != nil {
+		log.Printf("Failed to notify native user %s: %v", nativeUserID, err)
 	}
+}
 
-	return nil
+// findMatch looks for a partner who practices language, one of newEntry's native languages, and
+// puts both users on hold. Reciprocal partners are preferred. If there are none, a tutor who
+// speaks one of newEntry's practice languages is used; only the listener of newEntry's first
+// native language looks for tutors, so that they are not claimed several times over.
+// Candidates whose level does not suit the other user are skipped; among equally suitable ones,
+// partners with better ratings from past sessions go first.
+func (ms *MatchmakingService) findMatch(ctx context.Context, newEntry QueueEntry, language string) (*match, error) {
+	learners, err := ms.queuedEntries(ctx, queueKeyPrefix+language)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	widenInterval := ms.config.LevelWidenInterval
+
+	candidates := rankCandidates(newEntry, language, learners, ms.partnerRatings(ctx, learners), now, widenInterval)
+	if len(candidates) == 0 && newEntry.MatchMode == MatchModeReciprocal && language == newEntry.NativeLanguages[0] {
+		for _, practiceLanguage := range newEntry.PracticeLanguages {
+			tutors, err := ms.queuedEntries(ctx, tutorsKeyPrefix+practiceLanguage)
+			if err != nil {
+				return nil, err
+			}
+			for _, tutor := range tutors {
+				if tutor.UserID != newEntry.UserID && tutor.speaksNatively(practiceLanguage) &&
+					tutor.acceptsLevel(newEntry.Levels[practiceLanguage], now, widenInterval) {
+					candidates = append(candidates, match{nativeEntry: tutor, practiceEntry: newEntry, language: practiceLanguage})
+				}
+			}
+		}
+
+		tutors := make([]QueueEntry, len(candidates))
+		for i, candidate := range candidates {
+			tutors[i] = candidate.nativeEntry
+		}
+		ratings := ms.partnerRatings(ctx, tutors)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return ratings[candidates[i].nativeEntry.UserID] > ratings[candidates[j].nativeEntry.UserID]
+		})
+	}
+
+	for _, candidate := range candidates {
+		partner := candidate.practiceEntry
+		if partner.UserID == newEntry.UserID {
+			partner = candidate.nativeEntry
+		}
+
+		// Blocked pairs are skipped before the hold, so the candidate keeps their queue position
+		blocked, err := ms.blockChecker.IsBlocked(ctx, newEntry.UserID, partner.UserID)
+		if err != nil {
+			log.Printf("Failed to check blocks between %s and %s, skipping candidate: %v", newEntry.UserID, partner.UserID, err)
+			continue
+		}
+		if blocked {
+			continue
+		}
+
+		// Put the candidate on hold (this atomically removes them from all of their queues)
+		partnerEntry, err := ms.putUserOnHold(ctx, partner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to put user on hold: %w", err)
+		}
+		if partnerEntry == nil {
+			continue // Claimed by another match, try the next candidate
+		}
+
+		// Claim the new user as well; they may have been matched or re-joined the queue
+		// while this message was waiting to be processed
+		claimedEntry, err := ms.putUserOnHold(ctx, newEntry)
+		if err != nil || claimedEntry == nil {
+			if restoreErr := ms.restoreUserFromHold(ctx, partnerEntry.UserID, partnerEntry.holdLanguage()); restoreErr != nil {
+				log.Printf("Failed to restore user %s from hold: %v", partnerEntry.UserID, restoreErr)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to put user on hold: %w", err)
+			}
+			log.Printf("User %s is no longer queued, skipping match", newEntry.UserID)
+			return nil, nil
+		}
+
+		if candidate.nativeEntry.UserID == newEntry.UserID {
+			return &match{nativeEntry: *claimedEntry, practiceEntry: *partnerEntry, language: candidate.language}, nil
+		}
+		return &match{nativeEntry: *partnerEntry, practiceEntry: *claimedEntry, language: candidate.language}, nil
+	}
+
+	return nil, nil
 }
 
-func (ms *MatchmakingService) findMatch(ctx context.Context, nativeEntry QueueEntry) (*QueueEntry, error) {
-	language := nativeEntry.NativeLanguage
-	queueKey := "queue:" + language
+// partnerRatings returns the average rating of each queued user, with users who have not been
+// rated yet in the middle of the scale. If the ratings cannot be read, every user gets the same
+// rating so that candidates keep queue order.
+func (ms *MatchmakingService) partnerRatings(ctx context.Context, entries []QueueEntry) map[string]float64 {
+	ratings := make(map[string]float64, len(entries))
+	if len(entries) == 0 {
+		return ratings
+	}
+
+	userIDs := make([]string, len(entries))
+	for i, entry := range entries {
+		userIDs[i] = entry.UserID
+	}
 
-	// Get the next user from the queue without removing them yet
-	userID, err := ms.redisClient.LIndex(ctx, queueKey, 0).Result()
+	reputations, err := ms.reputations.GetReputations(ctx, userIDs)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			log.Printf("No user in '%s' queue on pop", language)
-			return nil, nil // No users in queue
+		log.Printf("Failed to get reputations of %d candidates, ranking without them: %v", len(userIDs), err)
+		return ratings
+	}
+
+	for _, userID := range userIDs {
+		ratings[userID] = float64(feedback.MinRating+feedback.MaxRating) / 2
+		if reputation := reputations[userID]; reputation != nil && reputation.RatingCount > 0 {
+			ratings[userID] = reputation.AverageRating
 		}
-		return nil, fmt.Errorf("failed to peek queue '%s': %w", queueKey, err)
+	}
+	return ratings
+}
+
+// rankCandidates orders the learners of language, one of newEntry's native languages, by how
+// well they suit newEntry, dropping those it cannot be paired with. Equally suitable learners are
+// ordered by their rating; ties keep queue order.
+func rankCandidates(newEntry QueueEntry, language string, learners []QueueEntry, ratings map[string]float64, now time.Time, widenInterval time.Duration) []match {
+	type scored struct {
+		entry QueueEntry
+		score int
 	}
 
-	// Put the user on hold (this atomically removes from queue and places in hold)
-	practiceEntry, err := ms.putUserOnHold(ctx, userID, language)
-	if err != nil {
-		return nil, fmt.Errorf("failed to put user on hold: %w", err)
+	var ranked []scored
+	for _, learner := range learners {
+		if learner.UserID == newEntry.UserID {
+			continue
+		}
+		if score := scoreCandidate(newEntry, language, learner, now, widenInterval); score > 0 {
+			ranked = append(ranked, scored{entry: learner, score: score})
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ratings[ranked[i].entry.UserID] > ratings[ranked[j].entry.UserID]
+	})
+
+	candidates := make([]match, len(ranked))
+	for i, r := range ranked {
+		candidates[i] = match{nativeEntry: newEntry, practiceEntry: r.entry, language: language}
 	}
+	return candidates
+}
 
-	if practiceEntry == nil {
-		log.Printf("No user in queue on pop, %s", userID)
-		return nil, nil // No user was available (race condition)
+// scoreCandidate scores a learner of language for newEntry. Reciprocal partners, who speak one of
+// newEntry's practice languages natively, score highest; one-way pairings are only allowed when
+// newEntry opted into tutor mode. Each user who practices must have a level the other accepts.
+func scoreCandidate(newEntry QueueEntry, language string, learner QueueEntry, now time.Time, widenInterval time.Duration) int {
+	if !newEntry.acceptsLevel(learner.Levels[language], now, widenInterval) {
+		return 0
 	}
 
-	return practiceEntry, nil
+	for _, practiceLanguage := range newEntry.PracticeLanguages {
+		if learner.speaksNatively(practiceLanguage) && learner.acceptsLevel(newEntry.Levels[practiceLanguage], now, widenInterval) {
+			return 2
+		}
+	}
+
+	if newEntry.MatchMode == MatchModeTutor {
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,93 @@
+package matchmaking
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	timeToMatch = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "langapp",
+		Subsystem: "matchmaking",
+		Name:      "time_to_match_seconds",
+		Help:      "Time from joining the queue to being matched, observed for both users of a match.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 180, 300, 600},
+	}, []string{"language"})
+
+	holdOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "langapp",
+		Subsystem: "matchmaking",
+		Name:      "hold_operations_total",
+		Help:      "Users put on hold for a match, released after a match or restored to their queues.",
+	}, []string{"operation"})
+
+	holdConflicts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "langapp",
+		Subsystem: "matchmaking",
+		Name:      "hold_conflicts_total",
+		Help:      "Users who could not be put on hold because another match claimed them first.",
+	})
+
+	proposalOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "langapp",
+		Subsystem: "matchmaking",
+		Name:      "proposal_outcomes_total",
+		Help:      "Match proposals accepted by both users, declined, or not accepted in time.",
+	}, []string{"outcome"})
+)
+
+const (
+	holdOperationHold    = "hold"
+	holdOperationRelease = "release"
+	holdOperationRestore = "restore"
+
+	proposalOutcomeAccepted = "accepted"
+	proposalOutcomeDeclined = "declined"
+	proposalOutcomeTimeout  = "timeout"
+)
+
+// queueCollectTimeout bounds the Redis round trip made on each scrape
+const queueCollectTimeout = 5 * time.Second
+
+var queueLengthDesc = prometheus.NewDesc(
+	"langapp_matchmaking_queue_length",
+	"Users waiting in the queue of each practice language.",
+	[]string{"language"}, nil,
+)
+
+type queueCollector struct {
+	ms *MatchmakingService
+}
+
+// QueueCollector reports the length of every language queue, read from Redis when scraped
+func (ms *MatchmakingService) QueueCollector() prometheus.Collector {
+	return &queueCollector{ms: ms}
+}
+
+func (qc *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueLengthDesc
+}
+
+func (qc *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueCollectTimeout)
+	defer cancel()
+
+	pipe := qc.ms.redisClient.Pipeline()
+	lengths := make([]*redis.IntCmd, len(qc.ms.languages))
+	for i, language := range qc.ms.languages {
+		lengths[i] = pipe.LLen(ctx, queueKeyPrefix+language)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to read queue lengths for metrics: %v", err)
+		return
+	}
+
+	for i, language := range qc.ms.languages {
+		ch <- prometheus.MustNewConstMetric(queueLengthDesc, prometheus.GaugeValue, float64(lengths[i].Val()), language)
+	}
+}
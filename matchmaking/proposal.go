@@ -0,0 +1,443 @@
+package matchmaking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"langapp-backend/session"
+	"langapp-backend/websocket"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// A match is first proposed to both users, who stay on hold until both accept it. Any instance
+// may resolve a proposal: the one receiving the last accept, a decline, or noticing the deadline.
+const (
+	proposalsKey      = "proposals"       // Sorted set of pending proposals scored by their deadline (unix milliseconds)
+	proposalKeyPrefix = "proposal:"       // Hash of a pending proposal, keyed by session ID
+	proposalUsersKey  = "proposals:users" // Hash of the session ID of each user's pending proposal
+
+	proposalExpiryInterval = time.Second
+)
+
+const (
+	MatchFailedDeclined = "declined"
+	MatchFailedTimeout  = "timeout"
+	MatchFailedError    = "error" // Both users accepted but the session could not be set up
+)
+
+var (
+	ErrNoProposal      = errors.New("match proposal expired or already resolved")
+	ErrNotInProposal   = errors.New("user is not part of the match proposal")
+	ErrInvalidRequest  = errors.New("invalid match response")
+	ErrProposalPending = errors.New("a match proposal is waiting for the user to answer")
+)
+
+// ProposalNotification is the payload of match_proposed messages. The user must accept the
+// match before ExpiresAt for the session to go ahead.
+type ProposalNotification struct {
+	SessionID string    `json:"session_id"`
+	PartnerID string    `json:"partner_id"`
+	Language  string    `json:"language"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Message   string    `json:"message"`
+}
+
+// MatchFailedNotification is the payload of match_failed messages, sent to both users when a
+// proposal was declined, not accepted in time, or could not be set up once accepted. Requeued tells whether the user is back at the
+// front of their queues; otherwise they were removed from matchmaking.
+type MatchFailedNotification struct {
+	SessionID string `json:"session_id"`
+	Reason    string `json:"reason"`
+	Requeued  bool   `json:"requeued"`
+	Message   string `json:"message"`
+}
+
+// MatchResponseRequest is the payload of accept_match and decline_match messages
+type MatchResponseRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+// proposal is a match waiting for both users to accept it. Each user is held under the hold
+// set of their hold language.
+type proposal struct {
+	sessionID      uuid.UUID
+	language       string
+	nativeUserID   string
+	nativeHold     string
+	practiceUserID string
+	practiceHold   string
+	accepted       map[string]bool
+	queuedAt       map[string]time.Time // When each user joined the queue, for the time to match
+}
+
+func (p *proposal) includes(userID string) bool {
+	return userID == p.nativeUserID || userID == p.practiceUserID
+}
+
+// holdLanguage returns the language whose hold set holds a user of the proposal
+func (p *proposal) holdLanguage(userID string) string {
+	if userID == p.nativeUserID {
+		return p.nativeHold
+	}
+	return p.practiceHold
+}
+
+func parseProposal(sessionID uuid.UUID, fields map[string]string) *proposal {
+	p := &proposal{
+		sessionID:      sessionID,
+		language:       fields["language"],
+		nativeUserID:   fields["native_user_id"],
+		nativeHold:     fields["native_hold_language"],
+		practiceUserID: fields["practice_user_id"],
+		practiceHold:   fields["practice_hold_language"],
+		accepted:       make(map[string]bool),
+		queuedAt:       make(map[string]time.Time),
+	}
+	for _, userID := range []string{p.nativeUserID, p.practiceUserID} {
+		p.accepted[userID] = fields["accepted:"+userID] != ""
+	}
+	if queuedAt, err := time.Parse(time.RFC3339Nano, fields["native_queued_at"]); err == nil {
+		p.queuedAt[p.nativeUserID] = queuedAt
+	}
+	if queuedAt, err := time.Parse(time.RFC3339Nano, fields["practice_queued_at"]); err == nil {
+		p.queuedAt[p.practiceUserID] = queuedAt
+	}
+	return p
+}
+
+// proposeMatch stores a proposal for the match, records it as pending for both users and asks
+// them to accept it. The hold clock of both users restarts, so that the hold reaper leaves them
+// alone until the deadline.
+func (ms *MatchmakingService) proposeMatch(ctx context.Context, m match, sessionID uuid.UUID) error {
+	now := time.Now()
+	expiresAt := now.Add(ms.config.AcceptTimeout)
+	key := proposalKeyPrefix + sessionID.String()
+
+	pipe := ms.redisClient.Pipeline()
+	pipe.HSet(ctx, key,
+		"language", m.language,
+		"native_user_id", m.nativeEntry.UserID,
+		"native_hold_language", m.nativeEntry.holdLanguage(),
+		"native_queued_at", m.nativeEntry.Timestamp.Format(time.RFC3339Nano),
+		"practice_user_id", m.practiceEntry.UserID,
+		"practice_hold_language", m.practiceEntry.holdLanguage(),
+		"practice_queued_at", m.practiceEntry.Timestamp.Format(time.RFC3339Nano),
+	)
+	pipe.HSet(ctx, proposalUsersKey, m.nativeEntry.UserID, sessionID.String(), m.practiceEntry.UserID, sessionID.String())
+	// Left for the expirer to resolve; only a leftover of a lost proposal ever expires
+	pipe.Expire(ctx, key, holdDataTTLFactor*ms.config.AcceptTimeout)
+	pipe.ZAdd(ctx, proposalsKey, redis.Z{Score: float64(expiresAt.UnixMilli()), Member: sessionID.String()})
+	for _, entry := range []QueueEntry{m.nativeEntry, m.practiceEntry} {
+		pipe.ZAddXX(ctx, holdSetKeyPrefix+entry.holdLanguage(), redis.Z{Score: float64(now.Unix()), Member: entry.UserID})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store match proposal for session '%s': %w", sessionID, err)
+	}
+
+	notifications := map[string]ProposalNotification{
+		m.practiceEntry.UserID: {
+			PartnerID: m.nativeEntry.UserID,
+			Message:   fmt.Sprintf("Match found! Accept to practice %s with %s", m.language, m.nativeEntry.UserID),
+		},
+		m.nativeEntry.UserID: {
+			PartnerID: m.practiceEntry.UserID,
+			Message:   fmt.Sprintf("Match found! Accept to help %s practice %s", m.practiceEntry.UserID, m.language),
+		},
+	}
+	for userID, notification := range notifications {
+		notification.SessionID = sessionID.String()
+		notification.Language = m.language
+		notification.ExpiresAt = expiresAt
+		if err := ms.wsManager.SendMessage(userID, websocket.Message{Type: websocket.MatchProposed, Data: notification}); err != nil {
+			log.Printf("Failed to send match proposal to user %s: %v", userID, err)
+		}
+	}
+
+	log.Printf("Proposed session %s to %s and %s, expires at %s", sessionID, m.nativeEntry.UserID, m.practiceEntry.UserID, expiresAt.Format(time.RFC3339))
+	return nil
+}
+
+func (ms *MatchmakingService) readProposal(ctx context.Context, sessionID uuid.UUID) (*proposal, error) {
+	fields, err := ms.redisClient.HGetAll(ctx, proposalKeyPrefix+sessionID.String()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read match proposal for session '%s': %w", sessionID, err)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return parseProposal(sessionID, fields), nil
+}
+
+// pendingProposal returns the session ID of the proposal waiting for the user to answer, if
+// any. An entry left behind by a proposal that was lost without being resolved is dropped.
+func (ms *MatchmakingService) pendingProposal(ctx context.Context, userID string) (uuid.UUID, bool, error) {
+	id, err := ms.redisClient.HGet(ctx, proposalUsersKey, userID).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return uuid.Nil, false, nil
+		}
+		return uuid.Nil, false, fmt.Errorf("failed to read pending match proposal of user '%s': %w", userID, err)
+	}
+
+	sessionID, err := uuid.Parse(id)
+	if err == nil {
+		var exists int64
+		exists, err = ms.redisClient.Exists(ctx, proposalKeyPrefix+id).Result()
+		if err != nil {
+			return uuid.Nil, false, fmt.Errorf("failed to read match proposal for session '%s': %w", id, err)
+		}
+		if exists > 0 {
+			return sessionID, true, nil
+		}
+	}
+
+	log.Printf("Dropping stale pending match proposal '%s' of user %s", id, userID)
+	if err := ms.redisClient.HDel(ctx, proposalUsersKey, userID).Err(); err != nil {
+		return uuid.Nil, false, fmt.Errorf("failed to drop pending match proposal of user '%s': %w", userID, err)
+	}
+	return uuid.Nil, false, nil
+}
+
+// claimProposal removes a proposal so that the caller alone resolves it. It returns nil if the
+// proposal was already resolved.
+func (ms *MatchmakingService) claimProposal(ctx context.Context, sessionID uuid.UUID) (*proposal, error) {
+	keys := []string{proposalKeyPrefix + sessionID.String(), proposalsKey, proposalUsersKey}
+	values, err := claimProposalScript.Run(ctx, ms.redisClient, keys, sessionID.String()).StringSlice()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim match proposal for session '%s': %w", sessionID, err)
+	}
+
+	fields := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		fields[values[i]] = values[i+1]
+	}
+	return parseProposal(sessionID, fields), nil
+}
+
+// AcceptMatch records that the user accepts a proposed match. Once both users have, the
+// session is marked matched, and they leave matchmaking and are told the session is ready.
+func (ms *MatchmakingService) AcceptMatch(ctx context.Context, userID string, sessionID uuid.UUID) error {
+	p, err := ms.readProposal(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return ErrNoProposal
+	}
+	if !p.includes(userID) {
+		return ErrNotInProposal
+	}
+
+	keys := []string{proposalKeyPrefix + sessionID.String(), proposalsKey, proposalUsersKey}
+	result, err := acceptScript.Run(ctx, ms.redisClient, keys, userID, sessionID.String()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to accept match proposal for session '%s': %w", sessionID, err)
+	}
+
+	switch result {
+	case -1:
+		return ErrNoProposal
+	case 0:
+		log.Printf("User %s accepted session %s, waiting for partner", userID, sessionID)
+		return nil
+	}
+
+	// The proposal is already gone from Redis, so a session that cannot be marked matched is
+	// failed here and both users, who accepted, go back to the front of their queues
+	if _, err := ms.sessionRepository.UpdateSession(ctx, sessionID, session.SessionProposed, session.SessionMatched); err != nil {
+		log.Printf("Failed to mark session %s matched: %v", sessionID, err)
+		ms.failProposal(ctx, p, MatchFailedError, func(string) bool { return true })
+		return nil
+	}
+
+	proposalOutcomes.WithLabelValues(proposalOutcomeAccepted).Inc()
+	if err := ms.recordMatch(ctx, p.language, sessionID); err != nil {
+		log.Printf("Failed to record match throughput for %s: %v", p.language, err)
+	}
+	for _, queuedAt := range p.queuedAt {
+		timeToMatch.WithLabelValues(p.language).Observe(time.Since(queuedAt).Seconds())
+	}
+
+	for _, heldUserID := range []string{p.nativeUserID, p.practiceUserID} {
+		if err := ms.releaseUserFromHold(ctx, heldUserID, p.holdLanguage(heldUserID)); err != nil {
+			log.Printf("Warning: failed to release user %s from hold after accepted match: %v", heldUserID, err)
+		}
+	}
+
+	log.Printf("Both users accepted session %s", sessionID)
+	ms.notifyMatchFound(sessionID, p.nativeUserID, p.practiceUserID, p.language)
+	return nil
+}
+
+// DeclineMatch fails a proposed match on behalf of the user, who leaves matchmaking while their
+// partner returns to the front of their queues
+func (ms *MatchmakingService) DeclineMatch(ctx context.Context, userID string, sessionID uuid.UUID) error {
+	p, err := ms.readProposal(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return ErrNoProposal
+	}
+	if !p.includes(userID) {
+		return ErrNotInProposal
+	}
+
+	p, err = ms.claimProposal(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return ErrNoProposal
+	}
+
+	proposalOutcomes.WithLabelValues(proposalOutcomeDeclined).Inc()
+	ms.failProposal(ctx, p, MatchFailedDeclined, func(candidate string) bool { return candidate != userID })
+	return nil
+}
+
+// failProposal marks the session of a claimed proposal failed. The users for which requeue
+// returns true go back to the front of their queues and are announced again; the others are
+// removed from matchmaking.
+func (ms *MatchmakingService) failProposal(ctx context.Context, p *proposal, reason string, requeue func(userID string) bool) {
+	_, err := ms.sessionRepository.UpdateSession(ctx, p.sessionID, session.SessionProposed, session.SessionFailed)
+	if err != nil {
+		log.Printf("Failed to mark session %s failed: %v", p.sessionID, err)
+	}
+
+	for _, userID := range []string{p.nativeUserID, p.practiceUserID} {
+		language := p.holdLanguage(userID)
+		notification := MatchFailedNotification{SessionID: p.sessionID.String(), Reason: reason}
+
+		if requeue(userID) {
+			if err := ms.requeueWithPriority(ctx, userID, language); err != nil {
+				log.Printf("Failed to requeue user %s after session %s failed: %v", userID, p.sessionID, err)
+			}
+			notification.Requeued = true
+			notification.Message = "Your partner did not accept the match. You are back at the front of the queue."
+			if reason == MatchFailedError {
+				notification.Message = "The session could not be set up. You are back at the front of the queue."
+			}
+		} else {
+			if err := ms.releaseUserFromHold(ctx, userID, language); err != nil {
+				log.Printf("Failed to remove user %s from matchmaking after session %s failed: %v", userID, p.sessionID, err)
+			}
+			notification.Message = "You did not accept the match in time and were removed from matchmaking."
+			if reason == MatchFailedDeclined {
+				notification.Message = "You declined the match and were removed from matchmaking."
+			}
+		}
+
+		if err := ms.wsManager.SendMessage(userID, websocket.Message{Type: websocket.MatchFailed, Data: notification}); err != nil {
+			log.Printf("Failed to notify user %s that session %s failed: %v", userID, p.sessionID, err)
+		}
+	}
+
+	log.Printf("Match proposal for session %s failed: %s", p.sessionID, reason)
+}
+
+// requeueWithPriority puts a held user back at the front of their queues and announces them
+// again, so that they are matched as soon as possible
+func (ms *MatchmakingService) requeueWithPriority(ctx context.Context, userID, language string) error {
+	entryJSON, err := ms.redisClient.HGet(ctx, holdDataKeyPrefix+userID, "data").Result()
+	if err != nil {
+		return fmt.Errorf("could not find hold data for user '%s': %w", userID, err)
+	}
+
+	var entry QueueEntry
+	if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+		return fmt.Errorf("failed to unmarshal hold data for user '%s': %w", userID, err)
+	}
+
+	if err := ms.restoreUserFromHold(ctx, userID, language); err != nil {
+		return err
+	}
+	return ms.announceEntry(ctx, entry, []byte(entryJSON))
+}
+
+// RunProposalExpirer fails the match proposals that were not accepted by both users in time.
+// Every instance runs it; each proposal is claimed by one of them. It returns when ctx is cancelled.
+func (ms *MatchmakingService) RunProposalExpirer(ctx context.Context) {
+	ticker := time.NewTicker(proposalExpiryInterval)
+	defer ticker.Stop()
+
+	log.Printf("Match proposal expirer started (accept timeout: %s)", ms.config.AcceptTimeout)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ms.expireProposals(ctx); err != nil {
+				log.Printf("Error expiring match proposals: %v", err)
+			}
+		}
+	}
+}
+
+func (ms *MatchmakingService) expireProposals(ctx context.Context) error {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	sessionIDs, err := ms.redisClient.ZRangeByScore(ctx, proposalsKey, &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read match proposals: %w", err)
+	}
+
+	for _, id := range sessionIDs {
+		sessionID, err := uuid.Parse(id)
+		if err != nil {
+			log.Printf("Dropping match proposal with invalid session ID '%s'", id)
+			ms.redisClient.ZRem(ctx, proposalsKey, id)
+			continue
+		}
+
+		p, err := ms.claimProposal(ctx, sessionID)
+		if err != nil {
+			log.Printf("Failed to expire match proposal for session %s: %v", sessionID, err)
+			continue
+		}
+		if p == nil {
+			// Resolved meanwhile, here or by another instance
+			continue
+		}
+
+		proposalOutcomes.WithLabelValues(proposalOutcomeTimeout).Inc()
+		ms.failProposal(ctx, p, MatchFailedTimeout, func(userID string) bool { return p.accepted[userID] })
+	}
+
+	return nil
+}
+
+// handleMatchResponse returns the handler of accept_match or decline_match messages
+func (ms *MatchmakingService) handleMatchResponse(respond func(ctx context.Context, userID string, sessionID uuid.UUID) error) websocket.HandlerFunc {
+	return func(ctx context.Context, userID string, data json.RawMessage) error {
+		var req MatchResponseRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return websocket.NewHandlerError(websocket.ErrorInvalidPayload, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+		}
+
+		sessionID, err := uuid.Parse(req.SessionID)
+		if err != nil {
+			return websocket.NewHandlerError(websocket.ErrorInvalidPayload, fmt.Errorf("%w: invalid session_id '%s'", ErrInvalidRequest, req.SessionID))
+		}
+
+		err = respond(ctx, userID, sessionID)
+		switch {
+		case errors.Is(err, ErrNoProposal):
+			return websocket.NewHandlerError(websocket.ErrorConflict, err)
+		case errors.Is(err, ErrNotInProposal):
+			return websocket.NewHandlerError(websocket.ErrorForbidden, err)
+		default:
+			return err
+		}
+	}
+}
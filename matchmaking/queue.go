@@ -3,7 +3,10 @@ package matchmaking
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"slices"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -16,17 +19,29 @@ type RedisClient interface {
 	RPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd
 	LLen(ctx context.Context, key string) *redis.IntCmd
 	LIndex(ctx context.Context, key string, index int64) *redis.StringCmd
+	LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
 	LRem(ctx context.Context, key string, count int64, value interface{}) *redis.IntCmd
+	LPos(ctx context.Context, key string, value string, args redis.LPosArgs) *redis.IntCmd
 	Publish(ctx context.Context, channel string, message interface{}) *redis.IntCmd
 	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
 	Pipeline() redis.Pipeliner
 	HGet(ctx context.Context, key, field string) *redis.StringCmd
+	HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd
 	HSet(ctx context.Context, key string, values ...interface{}) *redis.IntCmd
 	HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd
-	SAdd(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
-	SRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd
+	ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd
+	ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
+	ZRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
+	ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd
+	ZScore(ctx context.Context, key, member string) *redis.FloatCmd
 	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd
 	Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
+
+	// Script execution (EVAL/EVALSHA) for the atomic queue transitions in scripts.go
+	redis.Scripter
 }
 
 type PubSubManager interface {
@@ -35,25 +50,100 @@ type PubSubManager interface {
 	InitializeLanguagePublishers(languages []string) error
 }
 
+// MatchMode controls which partners a queued user accepts
+type MatchMode string
+
+const (
+	// MatchModeReciprocal only pairs users whose native and practice languages are complementary
+	MatchModeReciprocal MatchMode = "reciprocal"
+	// MatchModeTutor additionally lets the user help a learner of their native language
+	// without practicing their own practice language in return
+	MatchModeTutor MatchMode = "tutor"
+)
+
+// IsValid reports whether the mode is a known match mode
+func (m MatchMode) IsValid() bool {
+	return m == MatchModeReciprocal || m == MatchModeTutor
+}
+
+// QueueEntry is a user waiting for a partner. The user is queued for every practice language
+// and announced on the channel of every native language, and leaves all of them on the first match.
 type QueueEntry struct {
-	UserID           string    `json:"user_id"`
-	NativeLanguage   string    `json:"native_language"`
-	PracticeLanguage string    `json:"practice_language"`
-	Timestamp        time.Time `json:"timestamp"`
+	UserID            string    `json:"user_id"`
+	NativeLanguages   []string  `json:"native_languages"`
+	PracticeLanguages []string  `json:"practice_languages"`
+	Timestamp         time.Time `json:"timestamp"`
+	MatchPreferences
+}
+
+// speaksNatively reports whether language is one of the entry's native languages
+func (e QueueEntry) speaksNatively(language string) bool {
+	return slices.Contains(e.NativeLanguages, language)
+}
+
+// hasLanguages reports whether the entry can be queued; entries written by older versions of
+// the service, before users could queue for several languages, have no language lists
+func (e QueueEntry) hasLanguages() bool {
+	return len(e.NativeLanguages) > 0 && len(e.PracticeLanguages) > 0
+}
+
+// holdLanguage is the language whose hold set tracks the entry while it is held for a match
+func (e QueueEntry) holdLanguage() string {
+	return e.PracticeLanguages[0]
+}
+
+// listKeys returns the queue of every practice language and, in tutor mode, the tutors list
+// of every native language
+func (e QueueEntry) listKeys() []string {
+	keys := make([]string, 0, len(e.PracticeLanguages)+len(e.NativeLanguages))
+	for _, language := range e.PracticeLanguages {
+		keys = append(keys, queueKeyPrefix+language)
+	}
+	if e.MatchMode == MatchModeTutor {
+		for _, language := range e.NativeLanguages {
+			keys = append(keys, tutorsKeyPrefix+language)
+		}
+	}
+	return keys
 }
 
 const (
 	usersDataHashKey = "users:data"
+	queueKeyPrefix   = "queue:"
+	tutorsKeyPrefix  = "tutors:"
 )
 
-func (ms *MatchmakingService) InitiateMatchmaking(ctx context.Context, userID, nativeLanguage, practiceLanguage string) (*QueueEntry, error) {
+func (ms *MatchmakingService) InitiateMatchmaking(ctx context.Context, userID string, nativeLanguages, practiceLanguages []string, preferences MatchPreferences) (*QueueEntry, error) {
+	if ms.draining.Load() {
+		return nil, ErrDraining
+	}
+
+	if preferences.MatchMode == "" {
+		preferences.MatchMode = MatchModeReciprocal
+	}
+
 	entry := QueueEntry{
-		UserID:           userID,
-		NativeLanguage:   nativeLanguage,
-		PracticeLanguage: practiceLanguage,
-		Timestamp:        time.Now(),
+		UserID:            userID,
+		NativeLanguages:   nativeLanguages,
+		PracticeLanguages: practiceLanguages,
+		Timestamp:         time.Now(),
+		MatchPreferences:  preferences,
+	}
+	if !entry.hasLanguages() {
+		return nil, fmt.Errorf("user '%s' needs at least one native and one practice language", userID)
+	}
+
+	// A user with a pending proposal is on hold; re-joining would leave their partner waiting
+	if _, pending, err := ms.pendingProposal(ctx, userID); err != nil {
+		return nil, err
+	} else if pending {
+		return nil, ErrProposalPending
+	}
+
+	// Remove any previous queue entry, which may be for different languages
+	if err := ms.dequeueUserByID(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to remove previous entry for user '%s': %w", userID, err)
 	}
-	ms.dequeueUserByEntry(ctx, entry)
 
 	entryJSON, err := json.Marshal(entry)
 	if err != nil {
@@ -65,7 +155,7 @@ func (ms *MatchmakingService) InitiateMatchmaking(ctx context.Context, userID, n
 		return nil, fmt.Errorf("failed to enqueue user '%s': %w", entry.UserID, err)
 	}
 
-	err = ms.pubSubManager.PublishToLanguageChannel(ctx, entry.NativeLanguage, entryJSON)
+	err = ms.announceEntry(ctx, entry, entryJSON)
 	if err != nil {
 		return nil, err
 	}
@@ -73,8 +163,32 @@ func (ms *MatchmakingService) InitiateMatchmaking(ctx context.Context, userID, n
 	return &entry, nil
 }
 
+// announceEntry publishes a queued entry on the channel of each of its native languages, so
+// that the listeners look for a partner who practices one of them
+func (ms *MatchmakingService) announceEntry(ctx context.Context, entry QueueEntry, entryJSON []byte) error {
+	for _, language := range entry.NativeLanguages {
+		if err := ms.pubSubManager.PublishToLanguageChannel(ctx, language, entryJSON); err != nil {
+			return fmt.Errorf("failed to announce user '%s' on '%s' channel: %w", entry.UserID, language, err)
+		}
+	}
+	return nil
+}
+
+// CancelMatchmaking removes the user from their queues. A match proposed to the user is
+// declined, which returns their partner to the front of their queues.
 func (ms *MatchmakingService) CancelMatchmaking(ctx context.Context, userID string) error {
-	err := ms.dequeueUserByID(ctx, userID)
+	sessionID, pending, err := ms.pendingProposal(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if pending {
+		// A proposal resolved meanwhile may have requeued the user, who is then dequeued below
+		if err := ms.DeclineMatch(ctx, userID, sessionID); err != nil && !errors.Is(err, ErrNoProposal) {
+			return err
+		}
+	}
+
+	err = ms.dequeueUserByID(ctx, userID)
 	if err != nil {
 		return err
 	}
@@ -82,12 +196,9 @@ func (ms *MatchmakingService) CancelMatchmaking(ctx context.Context, userID stri
 }
 
 func (ms *MatchmakingService) enqueueUser(ctx context.Context, entry QueueEntry, value []byte) error {
-	queueKey := "queue:" + entry.PracticeLanguage
-	pipe := ms.redisClient.Pipeline()
-	pipe.HSet(ctx, usersDataHashKey, entry.UserID, value)
-	pipe.RPush(ctx, queueKey, entry.UserID)
-	_, err := pipe.Exec(ctx)
-	return err
+	keys := append([]string{usersDataHashKey}, entry.listKeys()...)
+
+	return enqueueScript.Run(ctx, ms.redisClient, keys, entry.UserID, value).Err()
 }
 
 func (ms *MatchmakingService) dequeueUserByID(ctx context.Context, userID string) error {
@@ -113,14 +224,65 @@ func (ms *MatchmakingService) dequeueUserByID(ctx context.Context, userID string
 }
 
 func (ms *MatchmakingService) dequeueUserByEntry(ctx context.Context, entry QueueEntry) error {
-	queueKey := "queue:" + entry.PracticeLanguage
-	pipe := ms.redisClient.Pipeline()
-	pipe.LRem(ctx, queueKey, 0, entry.UserID)
-	pipe.HDel(ctx, usersDataHashKey, entry.UserID)
-	_, err := pipe.Exec(ctx)
+	_, err := ms.dequeueEntry(ctx, entry, false)
 	return err
 }
 
+// dequeueEntry removes this specific queue entry, leaving any newer entry for the same user.
+// With queuedOnly, a user who is currently on hold for a match is not removed.
+// It reports whether the entry was removed from the queue.
+func (ms *MatchmakingService) dequeueEntry(ctx context.Context, entry QueueEntry, queuedOnly bool) (bool, error) {
+	keys := append([]string{usersDataHashKey}, entry.listKeys()...)
+	onlyQueued := "0"
+	if queuedOnly {
+		onlyQueued = "1"
+	}
+
+	removed, err := dequeueScript.Run(ctx, ms.redisClient, keys, entry.UserID, entry.Timestamp.Format(time.RFC3339Nano), onlyQueued).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to dequeue user '%s': %w", entry.UserID, err)
+	}
+	return removed > 0, nil
+}
+
+// queuedEntries returns the entries of the users listed under key, in queue order.
+// Users whose data is missing or unreadable are skipped.
+func (ms *MatchmakingService) queuedEntries(ctx context.Context, key string) ([]QueueEntry, error) {
+	userIDs, err := ms.redisClient.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %w", key, err)
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	values, err := ms.redisClient.HMGet(ctx, usersDataHashKey, userIDs...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data for users in '%s': %w", key, err)
+	}
+
+	entries := make([]QueueEntry, 0, len(values))
+	for i, value := range values {
+		entryJSON, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var entry QueueEntry
+		if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+			log.Printf("Skipping unreadable data for user %s: %v", userIDs[i], err)
+			continue
+		}
+		if !entry.hasLanguages() {
+			log.Printf("Skipping data without languages for user %s", userIDs[i])
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
 func (ms *MatchmakingService) InitializeLanguageChannels(ctx context.Context, languages []string) error {
 	return ms.pubSubManager.InitializeLanguagePublishers(languages)
 }
@@ -0,0 +1,157 @@
+package matchmaking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RunHoldReaper recovers users stranded in hold state, e.g. by an instance that crashed
+// mid-match, and reconciles the users data hash with the queues. It runs once immediately
+// and then every configured interval until ctx is cancelled.
+func (ms *MatchmakingService) RunHoldReaper(ctx context.Context) {
+	interval := ms.config.HoldReaperInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Hold reaper started (interval: %s)", interval)
+
+	for {
+		if err := ms.reapHolds(ctx); err != nil {
+			log.Printf("Error reaping holds: %v", err)
+		}
+		if err := ms.reconcileQueues(ctx); err != nil {
+			log.Printf("Error reconciling queues: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reapHolds restores every user held for longer than the hold TTL to the front of their queue
+func (ms *MatchmakingService) reapHolds(ctx context.Context) error {
+	cutoff := strconv.FormatInt(time.Now().Add(-ms.config.HoldTTL).Unix(), 10)
+
+	for _, language := range ms.languages {
+		holdSetKey := holdSetKeyPrefix + language
+		userIDs, err := ms.redisClient.ZRangeByScore(ctx, holdSetKey, &redis.ZRangeBy{Min: "-inf", Max: cutoff}).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read hold set '%s': %w", holdSetKey, err)
+		}
+
+		for _, userID := range userIDs {
+			log.Printf("Restoring user %s stranded in '%s' hold", userID, language)
+			if err := ms.restoreUserFromHold(ctx, userID, language); err != nil {
+				log.Printf("Failed to restore stranded user %s: %v", userID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// reconcileQueues requeues users that have data but are neither queued nor held, and drops
+// user IDs without data from the queues and tutors lists. Because the hash, lists and hold sets
+// are not read in one atomic step, an inconsistency is only acted on if it was already seen on
+// the previous pass.
+func (ms *MatchmakingService) reconcileQueues(ctx context.Context) error {
+	usersData, err := ms.redisClient.HGetAll(ctx, usersDataHashKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read users data: %w", err)
+	}
+
+	tracked := make(map[string]bool)
+	listed := make(map[string][]string) // User IDs in each queue and tutors list, by key
+	for _, language := range ms.languages {
+		for _, key := range []string{queueKeyPrefix + language, tutorsKeyPrefix + language} {
+			userIDs, err := ms.redisClient.LRange(ctx, key, 0, -1).Result()
+			if err != nil {
+				return fmt.Errorf("failed to read '%s': %w", key, err)
+			}
+			listed[key] = userIDs
+			for _, userID := range userIDs {
+				tracked[userID] = true
+			}
+		}
+
+		heldIDs, err := ms.redisClient.ZRange(ctx, holdSetKeyPrefix+language, 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read '%s' hold set: %w", language, err)
+		}
+		for _, userID := range heldIDs {
+			tracked[userID] = true
+		}
+	}
+
+	suspects := make(map[string]bool)
+
+	for userID, entryJSON := range usersData {
+		if tracked[userID] {
+			continue
+		}
+
+		key := "data:" + userID
+		suspects[key] = true
+		if !ms.reaperSuspects[key] {
+			continue
+		}
+
+		if err := ms.requeueOrphan(ctx, userID, entryJSON); err != nil {
+			log.Printf("Failed to requeue orphaned user %s: %v", userID, err)
+		}
+	}
+
+	for listKey, userIDs := range listed {
+		for _, userID := range userIDs {
+			if _, exists := usersData[userID]; exists {
+				continue
+			}
+
+			key := "list:" + listKey + ":" + userID
+			suspects[key] = true
+			if !ms.reaperSuspects[key] {
+				continue
+			}
+
+			log.Printf("Removing user %s without data from '%s'", userID, listKey)
+			if err := ms.redisClient.LRem(ctx, listKey, 0, userID).Err(); err != nil {
+				log.Printf("Failed to remove user %s from '%s': %v", userID, listKey, err)
+			}
+		}
+	}
+
+	ms.reaperSuspects = suspects
+	return nil
+}
+
+// requeueOrphan puts a user with data but no queue position back at the front of their queues
+// and announces them again so that they can be matched
+func (ms *MatchmakingService) requeueOrphan(ctx context.Context, userID, entryJSON string) error {
+	var entry QueueEntry
+	if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+		log.Printf("Dropping unreadable data for user %s: %v", userID, err)
+		return ms.redisClient.HDel(ctx, usersDataHashKey, userID).Err()
+	}
+	if !entry.hasLanguages() {
+		log.Printf("Dropping data without languages for user %s", userID)
+		return ms.redisClient.HDel(ctx, usersDataHashKey, userID).Err()
+	}
+
+	log.Printf("Requeueing orphaned user %s to %v queues", userID, entry.PracticeLanguages)
+
+	// With no hold data, restoring falls back to the users data hash
+	if err := ms.restoreUserFromHold(ctx, userID, entry.holdLanguage()); err != nil {
+		return err
+	}
+
+	return ms.announceEntry(ctx, entry, []byte(entryJSON))
+}
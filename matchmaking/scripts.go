@@ -0,0 +1,158 @@
+package matchmaking
+
+import "github.com/redis/go-redis/v9"
+
+// Queue transitions run as server-side Lua scripts so that each one is a single atomic step,
+// even with several backend instances sharing the same Redis. Scripts are invoked with EVALSHA
+// and loaded on first use.
+//
+// Queue entries are identified by their timestamp, so a script given a stale entry (the user
+// re-joined the queue in the meantime) leaves the newer entry alone.
+
+// A user is listed in the queue of every language they practice and, in tutor mode, in the
+// tutors list of every language they speak natively. Scripts receive these lists as their
+// trailing keys, after the fixed keys documented on each script.
+
+// enqueueScript stores a user's entry and appends them to each of their lists.
+//
+// KEYS: users data hash, queues and tutors lists...
+// ARGV: user ID, entry JSON
+var enqueueScript = redis.NewScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+for i = 2, #KEYS do
+	redis.call('LREM', KEYS[i], 0, ARGV[1])
+	redis.call('RPUSH', KEYS[i], ARGV[1])
+end
+return 1
+`)
+
+// dequeueScript removes a user from all of their lists and deletes their entry.
+// With a timestamp, only that entry is removed. With queuedOnly, nothing happens unless the
+// user was still listed, so users on hold for a match are left alone.
+// Returns the number of list entries removed.
+//
+// KEYS: users data hash, queues and tutors lists...
+// ARGV: user ID, entry timestamp or "", "1" if queued only
+var dequeueScript = redis.NewScript(`
+if ARGV[2] ~= '' then
+	local data = redis.call('HGET', KEYS[1], ARGV[1])
+	if not data or cjson.decode(data).timestamp ~= ARGV[2] then
+		return 0
+	end
+end
+local removed = 0
+for i = 2, #KEYS do
+	removed = removed + redis.call('LREM', KEYS[i], 0, ARGV[1])
+end
+if ARGV[3] == '1' and removed == 0 then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+return removed
+`)
+
+// holdScript moves a queued user into hold state while a match is set up, removing them from
+// all of their lists at once, and returns their entry JSON, or nil if that entry is no longer
+// queued.
+//
+// KEYS: users data hash, hold set, hold data, queues and tutors lists...
+// ARGV: user ID, entry timestamp, hold time (unix seconds), hold data TTL (seconds)
+var holdScript = redis.NewScript(`
+local data = redis.call('HGET', KEYS[1], ARGV[1])
+if not data or cjson.decode(data).timestamp ~= ARGV[2] then
+	return false
+end
+local removed = 0
+for i = 4, #KEYS do
+	removed = removed + redis.call('LREM', KEYS[i], 0, ARGV[1])
+end
+if removed == 0 then
+	return false
+end
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+redis.call('HSET', KEYS[3], 'data', data)
+redis.call('EXPIRE', KEYS[3], ARGV[4])
+return data
+`)
+
+// releaseScript removes a matched user from hold state and deletes their entry.
+//
+// KEYS: hold set, hold data, users data hash
+// ARGV: user ID
+var releaseScript = redis.NewScript(`
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('DEL', KEYS[2])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 1
+`)
+
+// restoreScript moves a user back to the front of each of their lists, using the hold data or,
+// if that has expired, the users data hash. Returns 0 if neither exists.
+//
+// KEYS: users data hash, hold set, hold data, queues and tutors lists...
+// ARGV: user ID
+var restoreScript = redis.NewScript(`
+local data = redis.call('HGET', KEYS[3], 'data')
+if not data then
+	data = redis.call('HGET', KEYS[1], ARGV[1])
+end
+if not data then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	return 0
+end
+for i = 4, #KEYS do
+	redis.call('LREM', KEYS[i], 0, ARGV[1])
+	redis.call('LPUSH', KEYS[i], ARGV[1])
+end
+redis.call('HSET', KEYS[1], ARGV[1], data)
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('DEL', KEYS[3])
+return 1
+`)
+
+// acceptScript records that a user accepted a match proposal. Once both users have, the
+// proposal is deleted along with the users' pending proposal entries and 1 returned;
+// otherwise 0, or -1 if the proposal was already resolved.
+//
+// KEYS: proposal hash, proposals set, pending proposals hash
+// ARGV: user ID, session ID
+var acceptScript = redis.NewScript(`
+local native = redis.call('HGET', KEYS[1], 'native_user_id')
+if not native then
+	return -1
+end
+local practice = redis.call('HGET', KEYS[1], 'practice_user_id')
+redis.call('HSET', KEYS[1], 'accepted:' .. ARGV[1], '1')
+if redis.call('HEXISTS', KEYS[1], 'accepted:' .. native) == 1 and redis.call('HEXISTS', KEYS[1], 'accepted:' .. practice) == 1 then
+	for _, user in ipairs({native, practice}) do
+		if redis.call('HGET', KEYS[3], user) == ARGV[2] then
+			redis.call('HDEL', KEYS[3], user)
+		end
+	end
+	redis.call('DEL', KEYS[1])
+	redis.call('ZREM', KEYS[2], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// claimProposalScript deletes a match proposal and the users' pending proposal entries so
+// that only one caller resolves it, and returns its fields, or nil if it was already resolved.
+//
+// KEYS: proposal hash, proposals set, pending proposals hash
+// ARGV: session ID
+var claimProposalScript = redis.NewScript(`
+local fields = redis.call('HGETALL', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+if #fields == 0 then
+	return false
+end
+for _, field in ipairs({'native_user_id', 'practice_user_id'}) do
+	local user = redis.call('HGET', KEYS[1], field)
+	if user and redis.call('HGET', KEYS[3], user) == ARGV[1] then
+		redis.call('HDEL', KEYS[3], user)
+	end
+end
+redis.call('DEL', KEYS[1])
+return fields
+`)
@@ -0,0 +1,98 @@
+package matchmaking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+var ErrDraining = errors.New("matchmaking is shutting down")
+
+// Drain stops the service from accepting new queue joins. Users already queued stay in
+// Redis so that other instances can match them.
+func (ms *MatchmakingService) Drain() {
+	ms.draining.Store(true)
+	log.Println("Matchmaking draining, no longer accepting queue joins")
+}
+
+// CheckListeners reports an error unless the service accepts queue joins and every language
+// channel listener is subscribed
+func (ms *MatchmakingService) CheckListeners() error {
+	if ms.draining.Load() {
+		return ErrDraining
+	}
+
+	ms.subscribedMutex.Lock()
+	defer ms.subscribedMutex.Unlock()
+
+	var missing []string
+	for _, language := range ms.languages {
+		if !ms.subscribed[language] {
+			missing = append(missing, language)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("not subscribed to the channels of %d languages: %s", len(missing), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (ms *MatchmakingService) setSubscribed(language string, subscribed bool) {
+	ms.subscribedMutex.Lock()
+	defer ms.subscribedMutex.Unlock()
+	ms.subscribed[language] = subscribed
+}
+
+// Wait blocks until every language channel listener has stopped or ctx is done. It returns the
+// languages whose listeners were still running, e.g. stuck on a hung Redis or Postgres call.
+func (ms *MatchmakingService) Wait(ctx context.Context) []string {
+	done := make(chan struct{})
+	go func() {
+		ms.listeners.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	ms.listeningMutex.Lock()
+	defer ms.listeningMutex.Unlock()
+
+	var busy []string
+	for _, language := range ms.languages {
+		if ms.listening[language] {
+			busy = append(busy, language)
+		}
+	}
+	return busy
+}
+
+func (ms *MatchmakingService) setListening(language string, listening bool) {
+	ms.listeningMutex.Lock()
+	defer ms.listeningMutex.Unlock()
+	ms.listening[language] = listening
+}
+
+// RestoreHeldUsers puts every user this instance still holds for a match back at the front
+// of their queue, instead of leaving them for another instance's hold reaper
+func (ms *MatchmakingService) RestoreHeldUsers(ctx context.Context) {
+	ms.heldMutex.Lock()
+	held := make(map[string]string, len(ms.heldUsers))
+	for userID, language := range ms.heldUsers {
+		held[userID] = language
+	}
+	ms.heldMutex.Unlock()
+
+	for userID, language := range held {
+		if err := ms.restoreUserFromHold(ctx, userID, language); err != nil {
+			log.Printf("Failed to restore held user %s on shutdown: %v", userID, err)
+			continue
+		}
+		log.Printf("Restored held user %s to '%s' queue on shutdown", userID, language)
+	}
+}
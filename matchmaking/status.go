@@ -0,0 +1,176 @@
+package matchmaking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"langapp-backend/websocket"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Sorted set of the sessions recently created in a language, scored by creation time (unix
+// seconds). It only keeps the matches of the configured throughput window.
+const matchesKeyPrefix = "matches:"
+
+type QueueState string
+
+const (
+	QueueStateNotQueued QueueState = "not_queued" // The user is not waiting for a partner
+	QueueStateQueued    QueueState = "queued"     // The user is waiting in their queues
+	QueueStateMatching  QueueState = "matching"   // The user is on hold while a match is set up
+)
+
+// QueueStatus tells a user whether and where they are queued. EstimatedWaitSeconds is the
+// shortest estimate over their queues, and is omitted when no queue has recent matches.
+type QueueStatus struct {
+	UserID               string          `json:"user_id"`
+	State                QueueState      `json:"state"`
+	QueuedAt             *time.Time      `json:"queued_at,omitempty"`
+	NativeLanguages      []string        `json:"native_languages,omitempty"`
+	PracticeLanguages    []string        `json:"practice_languages,omitempty"`
+	Queues               []LanguageQueue `json:"queues,omitempty"`
+	EstimatedWaitSeconds *int            `json:"estimated_wait_seconds,omitempty"`
+}
+
+// LanguageQueue is the user's place in the queue of one practice language. Position is
+// 1-based and 0 while the user is not listed, e.g. on hold.
+type LanguageQueue struct {
+	Language             string `json:"language"`
+	Position             int    `json:"position"`
+	Length               int    `json:"length"`
+	RecentMatches        int    `json:"recent_matches"`
+	EstimatedWaitSeconds *int   `json:"estimated_wait_seconds,omitempty"`
+}
+
+// GetQueueStatus reads the user's queue entry, their position in the queue of each practice
+// language and whether they are held for a match. Waits are estimated from the matches made
+// in each language during the throughput window, assuming the queue advances at that rate.
+func (ms *MatchmakingService) GetQueueStatus(ctx context.Context, userID string) (*QueueStatus, error) {
+	status := &QueueStatus{UserID: userID, State: QueueStateNotQueued}
+
+	data, err := ms.redisClient.HGet(ctx, usersDataHashKey, userID).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return status, nil
+		}
+		return nil, fmt.Errorf("failed to read queue entry of user '%s': %w", userID, err)
+	}
+
+	var entry QueueEntry
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode queue entry of user '%s': %w", userID, err)
+	}
+	if !entry.hasLanguages() {
+		return status, nil
+	}
+
+	status.State = QueueStateQueued
+	status.QueuedAt = &entry.Timestamp
+	status.NativeLanguages = entry.NativeLanguages
+	status.PracticeLanguages = entry.PracticeLanguages
+
+	err = ms.redisClient.ZScore(ctx, holdSetKeyPrefix+entry.holdLanguage(), userID).Err()
+	if err == nil {
+		status.State = QueueStateMatching
+	} else if !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to read hold state of user '%s': %w", userID, err)
+	}
+
+	now := time.Now()
+	windowStart := strconv.FormatInt(now.Add(-ms.config.ThroughputWindow).Unix(), 10)
+
+	for _, language := range entry.PracticeLanguages {
+		queue := LanguageQueue{Language: language}
+		queueKey := queueKeyPrefix + language
+
+		pipe := ms.redisClient.Pipeline()
+		positionCmd := pipe.LPos(ctx, queueKey, userID, redis.LPosArgs{})
+		lengthCmd := pipe.LLen(ctx, queueKey)
+		matchesCmd := pipe.ZCount(ctx, matchesKeyPrefix+language, windowStart, "+inf")
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("failed to read '%s' queue of user '%s': %w", language, userID, err)
+		}
+
+		if position, err := positionCmd.Result(); err == nil {
+			queue.Position = int(position) + 1
+		}
+		queue.Length = int(lengthCmd.Val())
+		queue.RecentMatches = int(matchesCmd.Val())
+
+		if queue.Position > 0 && queue.RecentMatches > 0 {
+			wait := estimateWait(queue.Position, queue.RecentMatches, ms.config.ThroughputWindow)
+			queue.EstimatedWaitSeconds = &wait
+			if status.EstimatedWaitSeconds == nil || wait < *status.EstimatedWaitSeconds {
+				status.EstimatedWaitSeconds = &wait
+			}
+		}
+
+		status.Queues = append(status.Queues, queue)
+	}
+
+	return status, nil
+}
+
+// estimateWait returns the seconds until the user at position leaves the queue if the queue
+// keeps losing one learner per match at the rate of matches per window
+func estimateWait(position, matches int, window time.Duration) int {
+	return int(math.Ceil(float64(position) * window.Seconds() / float64(matches)))
+}
+
+// recordMatch counts a session in the throughput of its language and drops the matches that
+// fell out of the window
+func (ms *MatchmakingService) recordMatch(ctx context.Context, language string, sessionID uuid.UUID) error {
+	now := time.Now()
+	key := matchesKeyPrefix + language
+
+	pipe := ms.redisClient.Pipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Unix()), Member: sessionID.String()})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(now.Add(-ms.config.ThroughputWindow).Unix(), 10))
+	pipe.Expire(ctx, key, ms.config.ThroughputWindow)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RegisterHandlers registers the queue status request, queue cancellation and match response
+// handlers with the WebSocket manager
+func (ms *MatchmakingService) RegisterHandlers() {
+	ms.wsManager.RegisterHandler(websocket.QueueStatusRequest, ms.handleQueueStatusRequest)
+	ms.wsManager.RegisterHandler(websocket.CancelQueue, ms.handleCancelQueue)
+	ms.wsManager.RegisterHandler(websocket.AcceptMatch, ms.handleMatchResponse(ms.AcceptMatch))
+	ms.wsManager.RegisterHandler(websocket.DeclineMatch, ms.handleMatchResponse(ms.DeclineMatch))
+}
+
+// handleQueueStatusRequest answers a queue status request with the sender's status
+func (ms *MatchmakingService) handleQueueStatusRequest(ctx context.Context, userID string, data json.RawMessage) error {
+	status, err := ms.GetQueueStatus(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return ms.wsManager.Reply(ctx, websocket.Message{
+		Type: websocket.QueueStatusUpdate,
+		Data: status,
+	})
+}
+
+// handleCancelQueue removes the sender from their queues and confirms it
+func (ms *MatchmakingService) handleCancelQueue(ctx context.Context, userID string, data json.RawMessage) error {
+	if err := ms.CancelMatchmaking(ctx, userID); err != nil {
+		return err
+	}
+
+	return ms.wsManager.Reply(ctx, websocket.Message{
+		Type: websocket.MatchmakingCancelled,
+		Data: CancelledNotification{
+			Reason:  CancelReasonRequested,
+			Message: "You left the matchmaking queue",
+		},
+	})
+}
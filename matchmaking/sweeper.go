@@ -0,0 +1,156 @@
+package matchmaking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"langapp-backend/websocket"
+)
+
+const (
+	sweeperLockKeyPrefix = "lock:matchmaking:sweeper:"
+
+	CancelReasonTimeout   = "timeout"
+	CancelReasonRequested = "requested"
+)
+
+type SearchingNotification struct {
+	Language       string `json:"language"`
+	QueuePosition  int    `json:"queue_position"`
+	QueueLength    int    `json:"queue_length"`
+	ElapsedSeconds int    `json:"elapsed_seconds"`
+}
+
+type CancelledNotification struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// RunSweeper periodically notifies queued users that matchmaking is still in progress and
+// removes users who have waited longer than the configured maximum. It returns when ctx is cancelled.
+func (ms *MatchmakingService) RunSweeper(ctx context.Context) {
+	interval := ms.config.SweeperInterval
+	maxWait := ms.config.MaxWait
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Matchmaking sweeper started (interval: %s, max wait: %s)", interval, maxWait)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Only one instance sweeps per interval, whatever the offset between their tickers:
+			// the lock is keyed by the interval the tick falls in and lasts for that interval
+			bucket := time.Now().UnixNano() / int64(interval)
+			acquired, err := ms.redisClient.SetNX(ctx, fmt.Sprintf("%s%d", sweeperLockKeyPrefix, bucket), 1, interval).Result()
+			if err != nil {
+				log.Printf("Error acquiring sweeper lock: %v", err)
+				continue
+			}
+			if !acquired {
+				continue
+			}
+
+			// Users queued for several languages are only announced again once per sweep
+			retried := make(map[string]bool)
+			for _, language := range ms.languages {
+				if err := ms.sweepQueue(ctx, language, maxWait, retried); err != nil {
+					log.Printf("Error sweeping '%s' queue: %v", language, err)
+				}
+			}
+		}
+	}
+}
+
+func (ms *MatchmakingService) sweepQueue(ctx context.Context, language string, maxWait time.Duration, retried map[string]bool) error {
+	entries, err := ms.queuedEntries(ctx, queueKeyPrefix+language)
+	if err != nil {
+		return err
+	}
+
+	// Expire first, so that the remaining users are told the length of the queue they are left in
+	now := time.Now()
+	remaining := make([]QueueEntry, 0, len(entries))
+	for _, entry := range entries {
+		if now.Sub(entry.Timestamp) > maxWait {
+			if err := ms.expireEntry(ctx, entry, maxWait); err != nil {
+				log.Printf("Failed to expire queue entry for user %s: %v", entry.UserID, err)
+			}
+			continue
+		}
+		remaining = append(remaining, entry)
+	}
+
+	for i, entry := range remaining {
+		elapsed := now.Sub(entry.Timestamp)
+		message := websocket.Message{
+			Type: websocket.StillSearching,
+			Data: SearchingNotification{
+				Language:       language,
+				QueuePosition:  i + 1,
+				QueueLength:    len(remaining),
+				ElapsedSeconds: int(elapsed.Seconds()),
+			},
+		}
+		if err := ms.wsManager.SendMessage(entry.UserID, message); err != nil {
+			log.Printf("Failed to notify user %s that matchmaking is ongoing: %v", entry.UserID, err)
+		}
+
+		if !retried[entry.UserID] && ms.levelRangeWidened(entry, elapsed) {
+			retried[entry.UserID] = true
+			if err := ms.retryMatch(ctx, entry); err != nil {
+				log.Printf("Failed to retry matching user %s: %v", entry.UserID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// levelRangeWidened reports whether the entry's accepted partner levels grew since the
+// previous sweep, so that partners it skipped earlier may now be suitable
+func (ms *MatchmakingService) levelRangeWidened(entry QueueEntry, elapsed time.Duration) bool {
+	if !entry.hasLevelRange() {
+		return false
+	}
+	interval := ms.config.LevelWidenInterval
+	return levelWidening(elapsed, interval) > levelWidening(elapsed-ms.config.SweeperInterval, interval)
+}
+
+// retryMatch announces a queued entry again so that the listeners look for a partner for it
+func (ms *MatchmakingService) retryMatch(ctx context.Context, entry QueueEntry) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ms.announceEntry(ctx, entry, entryJSON)
+}
+
+// expireEntry removes a user who waited too long and tells them matchmaking was cancelled
+func (ms *MatchmakingService) expireEntry(ctx context.Context, entry QueueEntry, maxWait time.Duration) error {
+	// A user who was just put on hold for a match is left alone
+	removed, err := ms.dequeueEntry(ctx, entry, true)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return nil
+	}
+
+	log.Printf("User %s removed from %v queues after waiting %s", entry.UserID, entry.PracticeLanguages, maxWait)
+
+	message := websocket.Message{
+		Type: websocket.MatchmakingCancelled,
+		Data: CancelledNotification{
+			Reason:  CancelReasonTimeout,
+			Message: fmt.Sprintf("No partner found for %s within %s. Please try again later.", strings.Join(entry.PracticeLanguages, ", "), maxWait),
+		},
+	}
+	return ms.wsManager.SendMessage(entry.UserID, message)
+}
@@ -0,0 +1,266 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"langapp-backend/session"
+	"langapp-backend/storage/postgres"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrReportExists   = errors.New("session already reported by this user")
+	ErrReportNotFound = errors.New("report not found")
+)
+
+type ReportCategory string
+
+const (
+	CategoryHarassment    ReportCategory = "harassment"
+	CategoryHateSpeech    ReportCategory = "hate_speech"
+	CategorySexualContent ReportCategory = "sexual_content"
+	CategorySpam          ReportCategory = "spam"
+	CategoryImpersonation ReportCategory = "impersonation"
+	CategoryUnderage      ReportCategory = "underage"
+	CategoryOther         ReportCategory = "other"
+)
+
+var Categories = []ReportCategory{
+	CategoryHarassment,
+	CategoryHateSpeech,
+	CategorySexualContent,
+	CategorySpam,
+	CategoryImpersonation,
+	CategoryUnderage,
+	CategoryOther,
+}
+
+func (c ReportCategory) IsValid() bool {
+	for _, category := range Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+type ReportStatus string
+
+const (
+	ReportOpen      ReportStatus = "open"      // Waiting for a moderator
+	ReportInReview  ReportStatus = "in_review" // A moderator is looking into it
+	ReportResolved  ReportStatus = "resolved"  // Action was taken
+	ReportDismissed ReportStatus = "dismissed" // No action needed
+)
+
+func (s ReportStatus) IsValid() bool {
+	switch s {
+	case ReportOpen, ReportInReview, ReportResolved, ReportDismissed:
+		return true
+	}
+	return false
+}
+
+// Report is an abuse report filed by a participant of a session against their partner. Session
+// is the reported session, so moderators see its language, time and duration.
+type Report struct {
+	ID             uuid.UUID        `json:"id"`
+	SessionID      uuid.UUID        `json:"session_id"`
+	ReporterID     string           `json:"reporter_id"`
+	ReportedUserID string           `json:"reported_user_id"`
+	Category       ReportCategory   `json:"category"`
+	Description    string           `json:"description"`
+	Status         ReportStatus     `json:"status"`
+	ModeratorID    string           `json:"moderator_id,omitempty"`
+	ModeratorNote  string           `json:"moderator_note,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+	Session        *session.Session `json:"session,omitempty"`
+}
+
+// ReportFilter narrows down the moderation queue. Zero fields do not filter.
+type ReportFilter struct {
+	Status         ReportStatus
+	Category       ReportCategory
+	ReportedUserID string
+	After          *session.Cursor
+	Limit          int
+}
+
+// ReportUpdate holds the triage changes a moderator makes to a report; nil fields are left as is
+type ReportUpdate struct {
+	Status *ReportStatus
+	Note   *string
+}
+
+type Repository struct {
+	db *postgres.PostgresClient
+}
+
+func NewRepository(db *postgres.PostgresClient) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+// CreateReport stores a new open report, returning ErrReportExists if its author already
+// reported that session
+func (r *Repository) CreateReport(ctx context.Context, report *Report) error {
+	query := `
+		INSERT INTO session_reports (session_id, reporter_id, reported_user_id, category, description)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, status, created_at, updated_at`
+
+	err := r.db.QueryRow(ctx, query,
+		report.SessionID, report.ReporterID, report.ReportedUserID, report.Category, report.Description,
+	).Scan(&report.ID, &report.Status, &report.CreatedAt, &report.UpdatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrReportExists
+		}
+		return fmt.Errorf("error querying database: %v", err)
+	}
+
+	return nil
+}
+
+const reportQuery = `
+	SELECT
+		r.id, r.session_id, r.reporter_id, r.reported_user_id, r.category, r.description, r.status,
+		COALESCE(r.moderator_id, ''), r.moderator_note, r.created_at, r.updated_at,
+		s.id, s.practice_user_id, s.native_user_id, s.language, s.status, s.created_at, s.updated_at,
+		s.started_at, s.ended_at, s.duration_seconds
+	FROM session_reports r
+	JOIN sessions s ON s.id = r.session_id`
+
+func scanReport(row pgx.Row) (*Report, error) {
+	var (
+		report Report
+		sess   session.Session
+	)
+	err := row.Scan(
+		&report.ID,
+		&report.SessionID,
+		&report.ReporterID,
+		&report.ReportedUserID,
+		&report.Category,
+		&report.Description,
+		&report.Status,
+		&report.ModeratorID,
+		&report.ModeratorNote,
+		&report.CreatedAt,
+		&report.UpdatedAt,
+		&sess.ID,
+		&sess.PracticeUserID,
+		&sess.NativeUserID,
+		&sess.Language,
+		&sess.Status,
+		&sess.CreatedAt,
+		&sess.UpdatedAt,
+		&sess.StartedAt,
+		&sess.EndedAt,
+		&sess.DurationSeconds,
+	)
+	if err != nil {
+		return nil, err
+	}
+	report.Session = &sess
+	return &report, nil
+}
+
+func (r *Repository) GetReportByID(ctx context.Context, reportID uuid.UUID) (*Report, error) {
+	report, err := scanReport(r.db.QueryRow(ctx, reportQuery+" WHERE r.id = $1", reportID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrReportNotFound
+		}
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	return report, nil
+}
+
+// ListReports returns a page of reports, newest first, and the cursor of the next page, which
+// is nil on the last page
+func (r *Repository) ListReports(ctx context.Context, filter ReportFilter) ([]Report, *session.Cursor, error) {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Status != "" {
+		addCondition("r.status = $%d", filter.Status)
+	}
+	if filter.Category != "" {
+		addCondition("r.category = $%d", filter.Category)
+	}
+	if filter.ReportedUserID != "" {
+		addCondition("r.reported_user_id = $%d", filter.ReportedUserID)
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.CreatedAt, filter.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(r.created_at, r.id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	// One extra row tells whether there is a next page
+	args = append(args, filter.Limit+1)
+	query := reportQuery + " WHERE " + strings.Join(conditions, " AND ") +
+		fmt.Sprintf(" ORDER BY r.created_at DESC, r.id DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error scanning report: %v", err)
+		}
+		reports = append(reports, *report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error querying database: %v", err)
+	}
+
+	if len(reports) <= filter.Limit {
+		return reports, nil, nil
+	}
+
+	reports = reports[:filter.Limit]
+	last := reports[len(reports)-1]
+	return reports, &session.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
+}
+
+// UpdateReport applies a moderator's triage to a report and records them as its moderator
+func (r *Repository) UpdateReport(ctx context.Context, reportID uuid.UUID, moderatorID string, update ReportUpdate) (*Report, error) {
+	query := `
+		UPDATE session_reports
+		SET status = COALESCE($3, status),
+			moderator_note = COALESCE($4, moderator_note),
+			moderator_id = $2,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`
+
+	tag, err := r.db.Exec(ctx, query, reportID, moderatorID, update.Status, update.Note)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrReportNotFound
+	}
+
+	return r.GetReportByID(ctx, reportID)
+}
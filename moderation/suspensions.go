@@ -0,0 +1,102 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Suspension keeps a user out of matchmaking until it expires or is lifted. A nil ExpiresAt
+// means it lasts until lifted.
+type Suspension struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      string     `json:"user_id"`
+	Reason      string     `json:"reason"`
+	ReportID    *uuid.UUID `json:"report_id,omitempty"`
+	SuspendedBy string     `json:"suspended_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// Message explains the suspension to the suspended user
+func (s *Suspension) Message() string {
+	if s.ExpiresAt != nil {
+		return fmt.Sprintf("Account suspended until %s: %s", s.ExpiresAt.UTC().Format(time.RFC3339), s.Reason)
+	}
+	return "Account suspended: " + s.Reason
+}
+
+// SuspendUser stores a new suspension
+func (r *Repository) SuspendUser(ctx context.Context, suspension *Suspension) error {
+	query := `
+		INSERT INTO user_suspensions (user_id, reason, report_id, suspended_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	err := r.db.QueryRow(ctx, query,
+		suspension.UserID, suspension.Reason, suspension.ReportID, suspension.SuspendedBy, suspension.ExpiresAt,
+	).Scan(&suspension.ID, &suspension.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error querying database: %v", err)
+	}
+
+	return nil
+}
+
+// LiftSuspensions lifts every active suspension of a user and reports whether there was any
+func (r *Repository) LiftSuspensions(ctx context.Context, userID, liftedBy string) (bool, error) {
+	query := `
+		UPDATE user_suspensions
+		SET lifted_at = CURRENT_TIMESTAMP, lifted_by = $2
+		WHERE user_id = $1 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`
+
+	tag, err := r.db.Exec(ctx, query, userID, liftedBy)
+	if err != nil {
+		return false, fmt.Errorf("error querying database: %v", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetActiveSuspension returns the suspension currently in force for a user, the one lasting
+// longest if there are several, or nil if the user is not suspended
+func (r *Repository) GetActiveSuspension(ctx context.Context, userID string) (*Suspension, error) {
+	query := `
+		SELECT id, user_id, reason, report_id, suspended_by, created_at, expires_at
+		FROM user_suspensions
+		WHERE user_id = $1 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		ORDER BY expires_at DESC NULLS FIRST
+		LIMIT 1`
+
+	var suspension Suspension
+	err := r.db.QueryRow(ctx, query, userID).Scan(
+		&suspension.ID,
+		&suspension.UserID,
+		&suspension.Reason,
+		&suspension.ReportID,
+		&suspension.SuspendedBy,
+		&suspension.CreatedAt,
+		&suspension.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+
+	return &suspension, nil
+}
+
+// SuspensionReason returns the message of the user's active suspension, or an empty string if
+// they are not suspended
+func (r *Repository) SuspensionReason(ctx context.Context, userID string) (string, error) {
+	suspension, err := r.GetActiveSuspension(ctx, userID)
+	if err != nil || suspension == nil {
+		return "", err
+	}
+	return suspension.Message(), nil
+}
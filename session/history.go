@@ -0,0 +1,274 @@
+package session
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the position after the last session of a page. Sessions are listed newest
+// first, ordered by creation time and then ID.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// String encodes the cursor as an opaque token for clients
+func (c Cursor) String() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes a token returned by Cursor.String
+func ParseCursor(token string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if cursor.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.ID, err = uuid.Parse(id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// SessionFilter narrows down a user's session history. Zero fields do not filter.
+type SessionFilter struct {
+	Language string
+	Status   SessionStatus
+	// From and To bound the creation time; From is inclusive, To exclusive
+	From  time.Time
+	To    time.Time
+	After *Cursor
+	Limit int
+}
+
+// ListSessionsByUserID returns a page of the user's sessions, newest first, and the cursor of
+// the next page, which is nil on the last page
+func (r *Repository) ListSessionsByUserID(ctx context.Context, userID string, filter SessionFilter) ([]Session, *Cursor, error) {
+	conditions := []string{"(practice_user_id = $1 OR native_user_id = $1)"}
+	args := []interface{}{userID}
+
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Language != "" {
+		addCondition("language = $%d", filter.Language)
+	}
+	if filter.Status != "" {
+		addCondition("status = $%d", filter.Status)
+	}
+	if !filter.From.IsZero() {
+		addCondition("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("created_at < $%d", filter.To)
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.CreatedAt, filter.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	// One extra row tells whether there is a next page
+	args = append(args, filter.Limit+1)
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE " + strings.Join(conditions, " AND ") +
+		fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error scanning session: %v", err)
+		}
+		sessions = append(sessions, *session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error querying database: %v", err)
+	}
+
+	if len(sessions) <= filter.Limit {
+		return sessions, nil, nil
+	}
+
+	sessions = sessions[:filter.Limit]
+	last := sessions[len(sessions)-1]
+	return sessions, &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
+}
+
+// UserStats summarizes a user's practice history
+type UserStats struct {
+	TotalSessions     int             `json:"total_sessions"`
+	CompletedSessions int             `json:"completed_sessions"`
+	FailedSessions    int             `json:"failed_sessions"`
+	TotalMinutes      int             `json:"total_minutes"`
+	Languages         []LanguageStats `json:"languages"`
+	// Streaks count consecutive days with at least one completed call, in the requested time zone
+	CurrentStreakDays int        `json:"current_streak_days"`
+	LongestStreakDays int        `json:"longest_streak_days"`
+	LastSessionAt     *time.Time `json:"last_session_at,omitempty"`
+}
+
+// LanguageStats summarizes the sessions held in one language. Practice minutes were spent
+// learning the language, native minutes helping others learn it.
+type LanguageStats struct {
+	Language          string `json:"language"`
+	Sessions          int    `json:"sessions"`
+	CompletedSessions int    `json:"completed_sessions"`
+	TotalMinutes      int    `json:"total_minutes"`
+	PracticeMinutes   int    `json:"practice_minutes"`
+	NativeMinutes     int    `json:"native_minutes"`
+}
+
+// GetUserStats computes the user's session counts, minutes per language and streaks. Days
+// are counted in loc.
+func (r *Repository) GetUserStats(ctx context.Context, userID string, loc *time.Location) (*UserStats, error) {
+	query := `
+		SELECT
+			language,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'completed'),
+			COUNT(*) FILTER (WHERE status = 'failed'),
+			COALESCE(SUM(duration_seconds) FILTER (WHERE practice_user_id = $1), 0),
+			COALESCE(SUM(duration_seconds) FILTER (WHERE native_user_id = $1), 0),
+			MAX(ended_at)
+		FROM sessions
+		WHERE practice_user_id = $1 OR native_user_id = $1
+		GROUP BY language
+		ORDER BY language`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	stats := UserStats{Languages: []LanguageStats{}}
+	totalSeconds := int64(0)
+	for rows.Next() {
+		var (
+			language                       LanguageStats
+			failed                         int
+			practiceSeconds, nativeSeconds int64
+			lastEndedAt                    *time.Time
+		)
+		err := rows.Scan(
+			&language.Language,
+			&language.Sessions,
+			&language.CompletedSessions,
+			&failed,
+			&practiceSeconds,
+			&nativeSeconds,
+			&lastEndedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning stats: %v", err)
+		}
+
+		language.PracticeMinutes = int(practiceSeconds / 60)
+		language.NativeMinutes = int(nativeSeconds / 60)
+		language.TotalMinutes = int((practiceSeconds + nativeSeconds) / 60)
+		stats.Languages = append(stats.Languages, language)
+
+		stats.TotalSessions += language.Sessions
+		stats.CompletedSessions += language.CompletedSessions
+		stats.FailedSessions += failed
+		totalSeconds += practiceSeconds + nativeSeconds
+		if lastEndedAt != nil && (stats.LastSessionAt == nil || lastEndedAt.After(*stats.LastSessionAt)) {
+			stats.LastSessionAt = lastEndedAt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	stats.TotalMinutes = int(totalSeconds / 60)
+
+	days, err := r.practiceDays(ctx, userID, loc)
+	if err != nil {
+		return nil, err
+	}
+	stats.CurrentStreakDays, stats.LongestStreakDays = streaks(days, time.Now().In(loc))
+
+	return &stats, nil
+}
+
+// practiceDays returns the distinct days, in loc and newest first, on which the user
+// finished a call that actually took place
+func (r *Repository) practiceDays(ctx context.Context, userID string, loc *time.Location) ([]time.Time, error) {
+	query := `
+		SELECT DISTINCT (ended_at AT TIME ZONE $2)::DATE AS day
+		FROM sessions
+		WHERE (practice_user_id = $1 OR native_user_id = $1)
+			AND status = 'completed' AND duration_seconds > 0 AND ended_at IS NOT NULL
+		ORDER BY day DESC`
+
+	rows, err := r.db.Query(ctx, query, userID, loc.String())
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	defer rows.Close()
+
+	var days []time.Time
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			return nil, fmt.Errorf("error scanning practice day: %v", err)
+		}
+		days = append(days, day)
+	}
+
+	return days, rows.Err()
+}
+
+// streaks computes the current and longest runs of consecutive days from days, which are
+// distinct and newest first. The current streak is still alive if its last day is today or
+// yesterday.
+func streaks(days []time.Time, now time.Time) (int, int) {
+	longest, run := 0, 0
+	for i, day := range days {
+		if i > 0 && civilDay(days[i-1])-civilDay(day) == 1 {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+	}
+
+	current := 0
+	if len(days) > 0 && civilDay(now)-civilDay(days[0]) <= 1 {
+		current = 1
+		for i := 1; i < len(days) && civilDay(days[i-1])-civilDay(days[i]) == 1; i++ {
+			current++
+		}
+	}
+
+	return current, longest
+}
+
+// civilDay numbers calendar days so that consecutive days differ by one
+func civilDay(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor Cursor
+	}{
+		{
+			name:   "utc",
+			cursor: Cursor{CreatedAt: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), ID: uuid.MustParse("7f1c2a4e-5b6d-4e8f-9a0b-1c2d3e4f5a6b")},
+		},
+		{
+			name:   "nanoseconds",
+			cursor: Cursor{CreatedAt: time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC), ID: uuid.MustParse("00000000-0000-0000-0000-000000000001")},
+		},
+		{
+			name:   "other time zone",
+			cursor: Cursor{CreatedAt: time.Date(2024, 3, 1, 23, 59, 59, 0, time.FixedZone("UTC+2", 2*60*60)), ID: uuid.MustParse("7f1c2a4e-5b6d-4e8f-9a0b-1c2d3e4f5a6b")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseCursor(tt.cursor.String())
+			if err != nil {
+				t.Fatalf("ParseCursor() error = %v", err)
+			}
+			if !parsed.CreatedAt.Equal(tt.cursor.CreatedAt) || parsed.ID != tt.cursor.ID {
+				t.Fatalf("ParseCursor() = %v, want %v", *parsed, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"no separator", encode("2024-03-01T12:30:00Z")},
+		{"invalid time", encode("yesterday|7f1c2a4e-5b6d-4e8f-9a0b-1c2d3e4f5a6b")},
+		{"invalid id", encode("2024-03-01T12:30:00Z|42")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCursor(tt.token); !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("ParseCursor(%q) error = %v, want %v", tt.token, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestStreaks(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time {
+		return time.Date(2024, 3, d, 9, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name        string
+		days        []time.Time
+		wantCurrent int
+		wantLongest int
+	}{
+		{"no days", nil, 0, 0},
+		{"today only", []time.Time{day(10)}, 1, 1},
+		{"yesterday keeps the streak alive", []time.Time{day(9), day(8)}, 2, 2},
+		{"two days ago breaks the streak", []time.Time{day(8), day(7)}, 0, 2},
+		{"longest streak in the past", []time.Time{day(10), day(6), day(5), day(4)}, 1, 3},
+		{"current streak is the longest", []time.Time{day(10), day(9), day(8), day(5)}, 3, 3},
+		{
+			name:        "across a month boundary",
+			days:        []time.Time{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+			wantCurrent: 0,
+			wantLongest: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, longest := streaks(tt.days, now)
+			if current != tt.wantCurrent || longest != tt.wantLongest {
+				t.Fatalf("streaks() = (%d, %d), want (%d, %d)", current, longest, tt.wantCurrent, tt.wantLongest)
+			}
+		})
+	}
+}
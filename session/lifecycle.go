@@ -0,0 +1,204 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"langapp-backend/websocket"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// transitions lists the statuses a session may move to from each status.
+// Completed and failed are final. Only matchmaking resolves proposed sessions.
+var transitions = map[SessionStatus][]SessionStatus{
+	SessionProposed:   {SessionMatched, SessionFailed},
+	SessionMatched:    {SessionConnecting, SessionFailed},
+	SessionConnecting: {SessionActive, SessionFailed},
+	SessionActive:     {SessionCompleted, SessionFailed},
+}
+
+var ErrNotParticipant = errors.New("user is not a participant of the session")
+
+// InvalidTransitionError is returned when a session cannot move from its current status to the requested one
+type InvalidTransitionError struct {
+	SessionID uuid.UUID
+	From      SessionStatus
+	To        SessionStatus
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("invalid session transition for '%s': %s -> %s", e.SessionID, e.From, e.To)
+}
+
+// CanTransition reports whether a session in status from may move to status to
+func CanTransition(from, to SessionStatus) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValid reports whether the status is a known session status
+func (s SessionStatus) IsValid() bool {
+	switch s {
+	case SessionProposed, SessionMatched, SessionConnecting, SessionActive, SessionCompleted, SessionFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsFinal reports whether no further transitions are possible from the status
+func (s SessionStatus) IsFinal() bool {
+	return s == SessionCompleted || s == SessionFailed
+}
+
+// PartnerID returns the other participant of the session, or false if userID is not a participant
+func (s *Session) PartnerID(userID string) (string, bool) {
+	switch userID {
+	case s.PracticeUserID:
+		return s.NativeUserID, true
+	case s.NativeUserID:
+		return s.PracticeUserID, true
+	default:
+		return "", false
+	}
+}
+
+type SessionStore interface {
+	GetSessionByID(ctx context.Context, sessionID uuid.UUID) (*Session, error)
+	UpdateSession(ctx context.Context, sessionID uuid.UUID, from, to SessionStatus) (*Session, error)
+}
+
+type LifecycleService struct {
+	sessionStore SessionStore
+	wsManager    *websocket.Manager
+}
+
+// SessionEventRequest is the payload of the connection and call messages sent by clients
+type SessionEventRequest struct {
+	SessionID string `json:"session_id"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// SessionNotification is the payload of the lifecycle messages broadcast to both participants
+type SessionNotification struct {
+	SessionID       string        `json:"session_id"`
+	Status          SessionStatus `json:"status"`
+	InitiatedBy     string        `json:"initiated_by"`
+	Reason          string        `json:"reason,omitempty"`
+	DurationSeconds *int32        `json:"duration_seconds,omitempty"`
+}
+
+func NewLifecycleService(sessionStore SessionStore, wsManager *websocket.Manager) *LifecycleService {
+	return &LifecycleService{
+		sessionStore: sessionStore,
+		wsManager:    wsManager,
+	}
+}
+
+// RegisterHandlers registers the session lifecycle message handlers with the WebSocket manager
+func (ls *LifecycleService) RegisterHandlers() {
+	ls.wsManager.RegisterHandler(websocket.InitiateConnection, ls.handlerFor(SessionConnecting, websocket.ConnectionInitiated))
+	ls.wsManager.RegisterHandler(websocket.ConnectionSuccess, ls.handlerFor(SessionActive, websocket.CallActive))
+	ls.wsManager.RegisterHandler(websocket.ConnectionFailure, ls.handlerFor(SessionFailed, websocket.ConnectionFailed))
+	ls.wsManager.RegisterHandler(websocket.EndCall, ls.handlerFor(SessionCompleted, websocket.CallEnded))
+}
+
+func (ls *LifecycleService) handlerFor(to SessionStatus, notification websocket.MessageType) websocket.HandlerFunc {
+	return func(ctx context.Context, userID string, data json.RawMessage) error {
+		var req SessionEventRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return websocket.NewHandlerError(websocket.ErrorInvalidPayload, fmt.Errorf("invalid session event payload: %w", err))
+		}
+
+		sessionID, err := uuid.Parse(req.SessionID)
+		if err != nil {
+			return websocket.NewHandlerError(websocket.ErrorInvalidPayload, fmt.Errorf("invalid session_id '%s'", req.SessionID))
+		}
+
+		session, err := ls.Transition(ctx, sessionID, userID, to)
+		if err != nil {
+			return transitionHandlerError(err)
+		}
+
+		ls.broadcast(session, notification, userID, req.Reason)
+		return nil
+	}
+}
+
+// transitionHandlerError tells the client why its session event was refused
+func transitionHandlerError(err error) error {
+	var transitionErr *InvalidTransitionError
+	switch {
+	case errors.Is(err, ErrNotParticipant), errors.Is(err, ErrSessionNotFound):
+		// Sessions of other users are not revealed
+		return websocket.NewHandlerError(websocket.ErrorForbidden, ErrNotParticipant)
+	case errors.As(err, &transitionErr), errors.Is(err, ErrSessionProposed):
+		return websocket.NewHandlerError(websocket.ErrorConflict, err)
+	default:
+		return err
+	}
+}
+
+// Transition moves a session to the given status on behalf of one of its participants.
+// An empty userID skips the participant check for transitions initiated by the server.
+// Proposed sessions are refused until matchmaking marks them matched.
+func (ls *LifecycleService) Transition(ctx context.Context, sessionID uuid.UUID, userID string, to SessionStatus) (*Session, error) {
+	session, err := ls.sessionStore.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session '%s': %w", sessionID, err)
+	}
+
+	if userID != "" {
+		if _, ok := session.PartnerID(userID); !ok {
+			return nil, fmt.Errorf("%w: user '%s', session '%s'", ErrNotParticipant, userID, sessionID)
+		}
+	}
+
+	if session.Status == SessionProposed {
+		return nil, fmt.Errorf("%w: session '%s'", ErrSessionProposed, sessionID)
+	}
+
+	if !CanTransition(session.Status, to) {
+		return nil, &InvalidTransitionError{SessionID: sessionID, From: session.Status, To: to}
+	}
+
+	updated, err := ls.sessionStore.UpdateSession(ctx, sessionID, session.Status, to)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The session changed status since it was read
+			return nil, &InvalidTransitionError{SessionID: sessionID, From: session.Status, To: to}
+		}
+		return nil, fmt.Errorf("failed to update session '%s': %w", sessionID, err)
+	}
+
+	log.Printf("Session %s transitioned %s -> %s", sessionID, session.Status, to)
+	return updated, nil
+}
+
+func (ls *LifecycleService) broadcast(session *Session, messageType websocket.MessageType, initiatedBy, reason string) {
+	message := websocket.Message{
+		Type: messageType,
+		Data: SessionNotification{
+			SessionID:       session.ID.String(),
+			Status:          session.Status,
+			InitiatedBy:     initiatedBy,
+			Reason:          reason,
+			DurationSeconds: session.DurationSeconds,
+		},
+	}
+
+	for _, userID := range []string{session.PracticeUserID, session.NativeUserID} {
+		if err := ls.wsManager.SendMessage(userID, message); err != nil {
+			log.Printf("Failed to notify user %s of session %s: %v", userID, session.ID, err)
+		}
+	}
+}
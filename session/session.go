@@ -2,16 +2,19 @@ package session
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"langapp-backend/storage/postgres"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 type SessionStatus string
 
 const (
+	SessionProposed   SessionStatus = "proposed"   // Match proposed, waiting for both users to accept
 	SessionMatched    SessionStatus = "matched"    // Users matched, not yet connected
 	SessionConnecting SessionStatus = "connecting" // WebRTC negotiation in progress
 	SessionActive     SessionStatus = "active"     // Audio call in progress
@@ -19,6 +22,11 @@ const (
 	SessionFailed     SessionStatus = "failed"     // Connection failed
 )
 
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionProposed = errors.New("session is waiting for both users to accept the match")
+)
+
 type Session struct {
 	ID              uuid.UUID     `json:"id"`
 	PracticeUserID  string        `json:"practice_user_id"`
@@ -27,6 +35,7 @@ type Session struct {
 	Status          SessionStatus `json:"status"`
 	CreatedAt       time.Time     `json:"created_at"`
 	UpdatedAt       time.Time     `json:"updated_at"`
+	StartedAt       *time.Time    `json:"started_at,omitempty"`
 	EndedAt         *time.Time    `json:"ended_at,omitempty"`
 	DurationSeconds *int32        `json:"duration_seconds,omitempty"`
 }
@@ -41,17 +50,19 @@ func NewRepository(db *postgres.PostgresClient) *Repository {
 	}
 }
 
+// CreateSession stores a proposed session, which becomes matched once both users accept it
 func (r *Repository) CreateSession(ctx context.Context, practiceUserID, nativeUserID, language string) (*Session, error) {
 	session := Session{
 		PracticeUserID: practiceUserID,
 		NativeUserID:   nativeUserID,
 		Language:       language,
+		Status:         SessionProposed,
 	}
 
 	err := r.db.QueryRow(
 		ctx,
-		"INSERT INTO sessions (practice_user_id, native_user_id, language) VALUES ($1, $2, $3) RETURNING id",
-		session.PracticeUserID, session.NativeUserID, session.Language,
+		"INSERT INTO sessions (practice_user_id, native_user_id, language, status) VALUES ($1, $2, $3, $4) RETURNING id",
+		session.PracticeUserID, session.NativeUserID, session.Language, session.Status,
 	).Scan(&session.ID)
 	if err != nil {
 		return nil, fmt.Errorf("error querying database: %v", err)
@@ -60,43 +71,86 @@ func (r *Repository) CreateSession(ctx context.Context, practiceUserID, nativeUs
 	return &session, nil
 }
 
-func (r *Repository) GetSessionByID(ctx context.Context, sessionID uuid.UUID) (*Session, error) {
+const sessionColumns = "id, practice_user_id, native_user_id, language, status, created_at, updated_at, started_at, ended_at, duration_seconds"
+
+func scanSession(row pgx.Row) (*Session, error) {
 	var session Session
-	err := r.db.QueryRow(
+	err := row.Scan(
+		&session.ID,
+		&session.PracticeUserID,
+		&session.NativeUserID,
+		&session.Language,
+		&session.Status,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.StartedAt,
+		&session.EndedAt,
+		&session.DurationSeconds,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *Repository) GetSessionByID(ctx context.Context, sessionID uuid.UUID) (*Session, error) {
+	session, err := scanSession(r.db.QueryRow(
 		ctx,
-		"SELECT id, practice_user_id, native_user_id, language, status FROM sessions WHERE id = $1",
+		"SELECT "+sessionColumns+" FROM sessions WHERE id = $1",
 		sessionID,
-	).Scan(&session.ID, &session.PracticeUserID, &session.NativeUserID, &session.Language, &session.Status)
+	))
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
 		return nil, fmt.Errorf("error querying database: %v", err)
 	}
 
-	return &session, nil
+	return session, nil
 }
 
+// GetSessionByUserID returns the user's most recent session
 func (r *Repository) GetSessionByUserID(ctx context.Context, userID string) (*Session, error) {
-	var session Session
-	err := r.db.QueryRow(
+	session, err := scanSession(r.db.QueryRow(
 		ctx,
-		"SELECT id, practice_user_id, native_user_id, language, status FROM sessions WHERE practice_user_id = $1 OR native_user_id = $1",
+		"SELECT "+sessionColumns+" FROM sessions WHERE practice_user_id = $1 OR native_user_id = $1 ORDER BY created_at DESC, id DESC LIMIT 1",
 		userID,
-	).Scan(&session.ID, &session.PracticeUserID, &session.NativeUserID, &session.Language, &session.Status)
+	))
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
 		return nil, fmt.Errorf("error querying database: %v", err)
 	}
 
-	return &session, nil
+	return session, nil
 }
 
-func (r *Repository) UpdateSession(ctx context.Context, sessionID uuid.UUID, status SessionStatus) error {
-	_, err := r.db.Exec(
-		ctx,
-		"UPDATE sessions SET status = $1 WHERE id = $2",
-		status, sessionID,
-	)
+// UpdateSession moves a session from one status to another. The update only applies if the
+// session is still in the from status, so concurrent transitions cannot overwrite each other;
+// in that case pgx.ErrNoRows is returned. Entering the active status records started_at, and
+// entering a final status records ended_at and duration_seconds.
+func (r *Repository) UpdateSession(ctx context.Context, sessionID uuid.UUID, from, to SessionStatus) (*Session, error) {
+	query := `
+		UPDATE sessions SET
+			status = $3,
+			started_at = CASE WHEN $3 = 'active' THEN CURRENT_TIMESTAMP ELSE started_at END,
+			ended_at = CASE WHEN $3 IN ('completed', 'failed') THEN CURRENT_TIMESTAMP ELSE ended_at END,
+			duration_seconds = CASE
+				WHEN $3 IN ('completed', 'failed') AND started_at IS NOT NULL
+				THEN EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - started_at))::INTEGER
+				ELSE duration_seconds
+			END
+		WHERE id = $1 AND status = $2
+		RETURNING ` + sessionColumns
+
+	session, err := scanSession(r.db.QueryRow(ctx, query, sessionID, from, to))
 	if err != nil {
-		return fmt.Errorf("error querying database: %v", err)
+		if err == pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error querying database: %v", err)
 	}
 
-	return nil
+	return session, nil
 }
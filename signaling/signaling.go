@@ -1 +1,159 @@
 package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"langapp-backend/session"
+	"langapp-backend/websocket"
+
+	"github.com/google/uuid"
+)
+
+type SignalType string
+
+const (
+	SignalOffer  SignalType = "offer"
+	SignalAnswer SignalType = "answer"
+	SignalICE    SignalType = "ice"
+)
+
+var (
+	ErrInvalidPayload  = errors.New("invalid signaling payload")
+	ErrNotParticipant  = session.ErrNotParticipant
+	ErrSessionInactive = errors.New("session is no longer accepting signaling messages")
+	ErrSessionProposed = session.ErrSessionProposed
+)
+
+type SessionRepository interface {
+	GetSessionByID(ctx context.Context, sessionID uuid.UUID) (*session.Session, error)
+}
+
+type SignalingService struct {
+	sessionRepository SessionRepository
+	wsManager         *websocket.Manager
+}
+
+// SignalingRequest is the payload of signaling_offer, signaling_answer and signaling_ice messages sent by clients
+type SignalingRequest struct {
+	SessionID string          `json:"session_id"`
+	SDP       string          `json:"sdp,omitempty"`
+	Candidate json.RawMessage `json:"candidate,omitempty"`
+}
+
+// SignalingNotification is the payload of signaling_message messages forwarded to the partner
+type SignalingNotification struct {
+	SessionID  string          `json:"session_id"`
+	From       string          `json:"from"`
+	SignalType SignalType      `json:"signal_type"`
+	SDP        string          `json:"sdp,omitempty"`
+	Candidate  json.RawMessage `json:"candidate,omitempty"`
+}
+
+func NewSignalingService(sessionRepository SessionRepository, wsManager *websocket.Manager) *SignalingService {
+	return &SignalingService{
+		sessionRepository: sessionRepository,
+		wsManager:         wsManager,
+	}
+}
+
+// RegisterHandlers registers the signaling message handlers with the WebSocket manager
+func (ss *SignalingService) RegisterHandlers() {
+	ss.wsManager.RegisterHandler(websocket.SignalingOffer, ss.handlerFor(SignalOffer))
+	ss.wsManager.RegisterHandler(websocket.SignalingAnswer, ss.handlerFor(SignalAnswer))
+	ss.wsManager.RegisterHandler(websocket.SignalingICE, ss.handlerFor(SignalICE))
+}
+
+func (ss *SignalingService) handlerFor(signalType SignalType) websocket.HandlerFunc {
+	return func(ctx context.Context, userID string, data json.RawMessage) error {
+		var req SignalingRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return websocket.NewHandlerError(websocket.ErrorInvalidPayload, fmt.Errorf("%w: %v", ErrInvalidPayload, err))
+		}
+		return handlerError(ss.Relay(ctx, userID, signalType, req))
+	}
+}
+
+// handlerError tells the client why its signaling message was refused
+func handlerError(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidPayload):
+		return websocket.NewHandlerError(websocket.ErrorInvalidPayload, err)
+	case errors.Is(err, ErrNotParticipant), errors.Is(err, session.ErrSessionNotFound):
+		// Sessions of other users are not revealed
+		return websocket.NewHandlerError(websocket.ErrorForbidden, ErrNotParticipant)
+	case errors.Is(err, ErrSessionInactive), errors.Is(err, ErrSessionProposed):
+		return websocket.NewHandlerError(websocket.ErrorConflict, err)
+	default:
+		return err
+	}
+}
+
+// Relay forwards a signaling message from a session participant to their partner
+func (ss *SignalingService) Relay(ctx context.Context, fromUserID string, signalType SignalType, req SignalingRequest) error {
+	if err := validateRequest(signalType, req); err != nil {
+		return err
+	}
+
+	sessionID, err := uuid.Parse(req.SessionID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid session_id '%s'", ErrInvalidPayload, req.SessionID)
+	}
+
+	sess, err := ss.sessionRepository.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get session '%s': %w", sessionID, err)
+	}
+
+	partnerID, ok := sess.PartnerID(fromUserID)
+	if !ok {
+		return fmt.Errorf("%w: user '%s', session '%s'", ErrNotParticipant, fromUserID, sessionID)
+	}
+
+	if sess.Status == session.SessionProposed {
+		return fmt.Errorf("%w: session '%s'", ErrSessionProposed, sessionID)
+	}
+	if sess.Status.IsFinal() {
+		return fmt.Errorf("%w: session '%s' is %s", ErrSessionInactive, sessionID, sess.Status)
+	}
+
+	message := websocket.Message{
+		Type: websocket.SignalingMessage,
+		Data: SignalingNotification{
+			SessionID:  sessionID.String(),
+			From:       fromUserID,
+			SignalType: signalType,
+			SDP:        req.SDP,
+			Candidate:  req.Candidate,
+		},
+	}
+
+	if err := ss.wsManager.SendMessage(partnerID, message); err != nil {
+		return fmt.Errorf("failed to forward %s to user '%s': %w", signalType, partnerID, err)
+	}
+
+	log.Printf("Relayed %s from %s to %s for session %s", signalType, fromUserID, partnerID, sessionID)
+	return nil
+}
+
+func validateRequest(signalType SignalType, req SignalingRequest) error {
+	if req.SessionID == "" {
+		return fmt.Errorf("%w: missing session_id", ErrInvalidPayload)
+	}
+
+	switch signalType {
+	case SignalOffer, SignalAnswer:
+		if req.SDP == "" {
+			return fmt.Errorf("%w: missing sdp for %s", ErrInvalidPayload, signalType)
+		}
+	case SignalICE:
+		if len(req.Candidate) == 0 {
+			return fmt.Errorf("%w: missing candidate", ErrInvalidPayload)
+		}
+	}
+
+	return nil
+}
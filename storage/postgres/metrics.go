@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolAcquiredConnsDesc = poolDesc("acquired_conns", "Connections currently in use.")
+	poolIdleConnsDesc     = poolDesc("idle_conns", "Idle connections in the pool.")
+	poolTotalConnsDesc    = poolDesc("total_conns", "Connections in the pool, in use or idle.")
+	poolMaxConnsDesc      = poolDesc("max_conns", "Maximum size of the pool.")
+	poolAcquiresDesc      = poolDesc("acquires_total", "Connections acquired from the pool.")
+	poolEmptyAcquiresDesc = poolDesc("empty_acquires_total", "Acquires that had to wait for a connection because the pool was empty.")
+	poolCanceledDesc      = poolDesc("canceled_acquires_total", "Acquires cancelled by their context before getting a connection.")
+	poolAcquireTimeDesc   = poolDesc("acquire_duration_seconds_total", "Total time spent acquiring connections.")
+)
+
+func poolDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc("langapp_postgres_pool_"+name, help, nil, nil)
+}
+
+type poolCollector struct {
+	client *PostgresClient
+}
+
+// PoolCollector reports the connection pool statistics of the client
+func (pc *PostgresClient) PoolCollector() prometheus.Collector {
+	return &poolCollector{client: pc}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConnsDesc
+	ch <- poolIdleConnsDesc
+	ch <- poolTotalConnsDesc
+	ch <- poolMaxConnsDesc
+	ch <- poolAcquiresDesc
+	ch <- poolEmptyAcquiresDesc
+	ch <- poolCanceledDesc
+	ch <- poolAcquireTimeDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.client.GetPool().Stat()
+
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConnsDesc, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConnsDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquiresDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolCanceledDesc, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireTimeDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Track when the audio call became active so call duration can be computed
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS started_at TIMESTAMP WITH TIME ZONE;
+
+-- +goose Down
+ALTER TABLE sessions DROP COLUMN IF EXISTS started_at;
@@ -0,0 +1,54 @@
+-- +goose Up
+-- Create users table; the id is the user ID issued by the identity provider
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(255) PRIMARY KEY,
+    display_name VARCHAR(100) NOT NULL DEFAULT '',
+    timezone VARCHAR(64),
+    avatar_url TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create trigger to automatically update updated_at column for users
+CREATE TRIGGER update_users_updated_at
+    BEFORE UPDATE ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Languages a user speaks natively
+CREATE TABLE IF NOT EXISTS user_native_languages (
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    language VARCHAR(100) NOT NULL REFERENCES languages(name),
+    position SMALLINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, language)
+);
+
+-- Languages a user is learning, with their CEFR proficiency level
+CREATE TABLE IF NOT EXISTS user_learning_languages (
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    language VARCHAR(100) NOT NULL REFERENCES languages(name),
+    proficiency VARCHAR(2) NOT NULL CHECK (proficiency IN ('A1', 'A2', 'B1', 'B2', 'C1', 'C2')),
+    position SMALLINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, language)
+);
+
+-- Create a user row for every participant of existing sessions before adding the foreign keys
+INSERT INTO users (id)
+    SELECT practice_user_id FROM sessions
+    UNION
+    SELECT native_user_id FROM sessions
+ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE sessions
+    ADD CONSTRAINT fk_sessions_practice_user FOREIGN KEY (practice_user_id) REFERENCES users(id),
+    ADD CONSTRAINT fk_sessions_native_user FOREIGN KEY (native_user_id) REFERENCES users(id);
+
+-- +goose Down
+ALTER TABLE sessions
+    DROP CONSTRAINT IF EXISTS fk_sessions_practice_user,
+    DROP CONSTRAINT IF EXISTS fk_sessions_native_user;
+
+DROP TABLE IF EXISTS user_learning_languages;
+DROP TABLE IF EXISTS user_native_languages;
+DROP TRIGGER IF EXISTS update_users_updated_at ON users;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Support paging through a user's session history, newest first
+CREATE INDEX IF NOT EXISTS idx_sessions_practice_user_history ON sessions(practice_user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_sessions_native_user_history ON sessions(native_user_id, created_at DESC, id DESC);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_sessions_practice_user_history;
+DROP INDEX IF EXISTS idx_sessions_native_user_history;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Ratings participants give each other after a completed session
+CREATE TABLE IF NOT EXISTS session_feedback (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    from_user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+    to_user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    -- Each participant can rate their partner once per session
+    UNIQUE (session_id, from_user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_feedback_to_user_id ON session_feedback(to_user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_session_feedback_to_user_id;
+DROP TABLE IF EXISTS session_feedback;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Users who must never be matched with each other; a block applies in both directions
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked_id ON user_blocks(blocked_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_user_blocks_blocked_id;
+DROP TABLE IF EXISTS user_blocks;
@@ -0,0 +1,42 @@
+-- +goose Up
+-- Abuse reports filed by a participant against their partner, triaged by moderators
+CREATE TABLE IF NOT EXISTS session_reports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    reporter_id VARCHAR(255) NOT NULL REFERENCES users(id),
+    reported_user_id VARCHAR(255) NOT NULL REFERENCES users(id),
+    category VARCHAR(50) NOT NULL CHECK (category IN ('harassment', 'hate_speech', 'sexual_content', 'spam', 'impersonation', 'underage', 'other')),
+    description TEXT NOT NULL DEFAULT '',
+    status VARCHAR(50) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'in_review', 'resolved', 'dismissed')),
+    moderator_id VARCHAR(255),
+    moderator_note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    -- Each participant can report their partner once per session
+    UNIQUE (session_id, reporter_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_reports_status_created_at ON session_reports(status, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_session_reports_reported_user_id ON session_reports(reported_user_id);
+
+-- Suspensions keep a user out of matchmaking; a suspension without expiry lasts until lifted
+CREATE TABLE IF NOT EXISTS user_suspensions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    report_id UUID REFERENCES session_reports(id) ON DELETE SET NULL,
+    suspended_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    lifted_at TIMESTAMP WITH TIME ZONE,
+    lifted_by VARCHAR(255)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_suspensions_user_id ON user_suspensions(user_id) WHERE lifted_at IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_user_suspensions_user_id;
+DROP TABLE IF EXISTS user_suspensions;
+DROP INDEX IF EXISTS idx_session_reports_reported_user_id;
+DROP INDEX IF EXISTS idx_session_reports_status_created_at;
+DROP TABLE IF EXISTS session_reports;
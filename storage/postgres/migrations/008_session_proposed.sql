@@ -0,0 +1,12 @@
+-- +goose Up
+-- Sessions start out proposed until both users accept the match
+INSERT INTO session_status (status, description) VALUES
+    ('proposed', 'Match proposed, waiting for both users to accept')
+ON CONFLICT (status) DO NOTHING;
+
+ALTER TABLE sessions ALTER COLUMN status SET DEFAULT 'proposed';
+
+-- +goose Down
+ALTER TABLE sessions ALTER COLUMN status SET DEFAULT 'matched';
+UPDATE sessions SET status = 'failed', ended_at = COALESCE(ended_at, CURRENT_TIMESTAMP) WHERE status = 'proposed';
+DELETE FROM session_status WHERE status = 'proposed';
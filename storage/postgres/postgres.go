@@ -4,8 +4,10 @@ import (
 	"context"
 	"embed"
 	"fmt"
+	"io/fs"
 	"log"
-	"os"
+
+	"langapp-backend/config"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -18,41 +20,38 @@ type PostgresClient struct {
 	pool *pgxpool.Pool
 }
 
-func NewPostgresClient(ctx context.Context) *PostgresClient {
-	// Default connection parameters for local development
-	host := getEnv("POSTGRES_HOST", "localhost")
-	port := getEnv("POSTGRES_PORT", "5432")
-	user := getEnv("POSTGRES_USER", "langapp")
-	password := getEnv("POSTGRES_PASSWORD", "langapp_dev")
-	dbname := getEnv("POSTGRES_DB", "langapp")
-
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
+func NewPostgresClient(ctx context.Context, cfg config.PostgresConfig) (*PostgresClient, error) {
+	dsn := cfg.DSN
+	if dsn == "" {
+		dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+			cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Database, cfg.SSLMode)
+	}
 
-	config, err := pgxpool.ParseConfig(dsn)
+	poolConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		log.Fatalf("Unable to parse postgres config: %v", err)
+		return nil, fmt.Errorf("unable to parse postgres config: %w", err)
 	}
 
 	// Configure connection pool
-	config.MaxConns = 25
-	config.MinConns = 5
+	poolConfig.MaxConns = cfg.MaxConns
+	poolConfig.MinConns = cfg.MinConns
 
-	pool, err := pgxpool.NewWithConfig(ctx, config)
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
-		log.Fatalf("Unable to create postgres connection pool: %v", err)
+		return nil, fmt.Errorf("unable to create postgres connection pool: %w", err)
 	}
 
 	// Test the connection
 	if err := pool.Ping(ctx); err != nil {
-		log.Fatalf("Unable to connect to postgres: %v", err)
+		pool.Close()
+		return nil, fmt.Errorf("unable to connect to postgres: %w", err)
 	}
 
-	log.Printf("Connected to PostgreSQL database: %s", dbname)
+	log.Printf("Connected to PostgreSQL database: %s", poolConfig.ConnConfig.Database)
 
 	return &PostgresClient{
 		pool: pool,
-	}
+	}, nil
 }
 
 func (pc *PostgresClient) Close() {
@@ -109,9 +108,41 @@ func (pc *PostgresClient) RunMigrations() error {
 	return nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// CheckMigrations reports an error unless the database schema is at the latest embedded
+// migration version
+func (pc *PostgresClient) CheckMigrations(ctx context.Context) error {
+	latest, err := latestMigrationVersion()
+	if err != nil {
+		return err
+	}
+
+	db := stdlib.OpenDBFromPool(pc.pool)
+	defer db.Close()
+
+	current, err := goose.GetDBVersionContext(ctx, db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current != latest {
+		return fmt.Errorf("schema is at version %d, expected %d", current, latest)
+	}
+	return nil
+}
+
+// latestMigrationVersion returns the version of the newest embedded migration
+func latestMigrationVersion() (int64, error) {
+	files, err := fs.Glob(embedMigrations, "migrations/*.sql")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	var latest int64
+	for _, file := range files {
+		version, err := goose.NumericComponent(file)
+		if err != nil {
+			return 0, fmt.Errorf("invalid migration file name '%s': %w", file, err)
+		}
+		latest = max(latest, version)
 	}
-	return defaultValue
+	return latest, nil
 }
@@ -1,11 +1,31 @@
 package redis
 
-import "github.com/redis/go-redis/v9"
-
-func NewRedisClient() *redis.Client {
-	return redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-	})
+import (
+	"crypto/tls"
+	"fmt"
+
+	"langapp-backend/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func NewRedisClient(cfg config.RedisConfig) (*redis.Client, error) {
+	if cfg.URL != "" {
+		options, err := redis.ParseURL(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis url: %w", err)
+		}
+		return redis.NewClient(options), nil
+	}
+
+	options := &redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	}
+	if cfg.TLS {
+		options.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	return redis.NewClient(options), nil
 }
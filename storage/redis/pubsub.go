@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -36,3 +37,45 @@ func (psm *PubSubManager) SubscribeToLanguageChannel(ctx context.Context, langua
 	channelName := fmt.Sprintf("matchmaking:%s", language)
 	return psm.client.Subscribe(ctx, channelName)
 }
+
+// clearPresenceScript deletes a presence key only if it still points at the given node, so a
+// node never clears the presence of a user who has since connected to another node
+var clearPresenceScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (psm *PubSubManager) PublishToNode(ctx context.Context, nodeID string, message interface{}) error {
+	channelName := fmt.Sprintf("websocket:node:%s", nodeID)
+	return psm.client.Publish(ctx, channelName, message).Err()
+}
+
+func (psm *PubSubManager) SubscribeToNode(ctx context.Context, nodeID string) *redis.PubSub {
+	channelName := fmt.Sprintf("websocket:node:%s", nodeID)
+	return psm.client.Subscribe(ctx, channelName)
+}
+
+// SetPresence records that userID is connected to nodeID. The record expires after ttl unless refreshed.
+func (psm *PubSubManager) SetPresence(ctx context.Context, userID, nodeID string, ttl time.Duration) error {
+	return psm.client.Set(ctx, presenceKey(userID), nodeID, ttl).Err()
+}
+
+// GetPresence returns the node userID is connected to, or an empty string if they are offline
+func (psm *PubSubManager) GetPresence(ctx context.Context, userID string) (string, error) {
+	nodeID, err := psm.client.Get(ctx, presenceKey(userID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return nodeID, err
+}
+
+// ClearPresence removes the presence record of userID if it belongs to nodeID
+func (psm *PubSubManager) ClearPresence(ctx context.Context, userID, nodeID string) error {
+	return clearPresenceScript.Run(ctx, psm.client, []string{presenceKey(userID)}, nodeID).Err()
+}
+
+func presenceKey(userID string) string {
+	return fmt.Sprintf("websocket:presence:%s", userID)
+}
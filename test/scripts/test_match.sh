@@ -10,7 +10,7 @@ echo ""
 # Check if server is running
 echo "🔍 Checking if server is running..."
 if ! curl -s http://localhost:8080/languages > /dev/null; then
-  echo "❌ Server not running. Start with: go run main.go"
+  echo "❌ Server not running. Start with: AUTH_DEV_MODE=true go run main.go"
   exit 1
 fi
 echo "✅ Server is running"
@@ -18,8 +18,8 @@ echo ""
 echo "📝 Joining matchmaking queue..."
 RESPONSE=$(curl -s -X POST "$BASE_URL/queue" \
   -H "Content-Type: application/json" \
+  -H "X-User-ID: $USER_ID" \
   -d "{
-    \"user_id\": \"$USER_ID\",
     \"native_language\": \"$NATIVE_LANG\",
     \"practice_language\": \"$PRACTICE_LANG\"
   }")
@@ -37,4 +37,4 @@ echo "🔌 To receive match notifications, open WebSocket connection in another
 echo "websocat ws://localhost:8080/ws?user_id=$USER_ID"
 echo ""
 echo "To cancel matchmaking, run:"
-echo "curl -X DELETE \"$BASE_URL/queue\" -H \"Content-Type: application/json\" -d '{\"user_id\": \"$USER_ID\"}'"
\ No newline at end of file
+echo "curl -X DELETE \"$BASE_URL/queue\" -H \"Content-Type: application/json\" -H \"X-User-ID: $USER_ID\" -d '{\"practice_language\": \"$PRACTICE_LANG\"}'"
\ No newline at end of file
@@ -0,0 +1,266 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"langapp-backend/languages"
+	"langapp-backend/storage/postgres"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrUserExists = errors.New("user profile already exists")
+
+type LearningLanguage struct {
+	Language    string          `json:"language"`
+	Proficiency languages.Level `json:"proficiency"`
+}
+
+type User struct {
+	ID                string             `json:"id"`
+	DisplayName       string             `json:"display_name"`
+	NativeLanguages   []string           `json:"native_languages"`
+	LearningLanguages []LearningLanguage `json:"learning_languages"`
+	Timezone          string             `json:"timezone,omitempty"`
+	AvatarURL         string             `json:"avatar_url,omitempty"`
+	CreatedAt         time.Time          `json:"created_at"`
+	UpdatedAt         time.Time          `json:"updated_at"`
+}
+
+// LearningLevel returns the user's proficiency in a language they are learning
+func (u *User) LearningLevel(language string) (languages.Level, bool) {
+	for _, learning := range u.LearningLanguages {
+		if learning.Language == language {
+			return learning.Proficiency, true
+		}
+	}
+	return "", false
+}
+
+// UserUpdate holds the profile fields to change; nil fields are left as they are
+type UserUpdate struct {
+	DisplayName       *string
+	NativeLanguages   []string
+	LearningLanguages []LearningLanguage
+	Timezone          *string
+	AvatarURL         *string
+}
+
+type Repository struct {
+	db *postgres.PostgresClient
+}
+
+func NewRepository(db *postgres.PostgresClient) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+// EnsureUser creates an empty user row if none exists, so that sessions can reference users
+// who have not set up a profile yet
+func (r *Repository) EnsureUser(ctx context.Context, userID string) error {
+	_, err := r.db.Exec(ctx, "INSERT INTO users (id) VALUES ($1) ON CONFLICT (id) DO NOTHING", userID)
+	if err != nil {
+		return fmt.Errorf("error querying database: %v", err)
+	}
+	return nil
+}
+
+// CreateUser stores a new profile. A row created by EnsureUser, which has no display name
+// yet, is completed; otherwise ErrUserExists is returned.
+func (r *Repository) CreateUser(ctx context.Context, user *User) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO users (id, display_name, timezone, avatar_url)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+		ON CONFLICT (id) DO UPDATE SET
+			display_name = EXCLUDED.display_name,
+			timezone = EXCLUDED.timezone,
+			avatar_url = EXCLUDED.avatar_url
+		WHERE users.display_name = ''
+		RETURNING created_at, updated_at`
+
+	err = tx.QueryRow(ctx, query, user.ID, user.DisplayName, user.Timezone, user.AvatarURL).Scan(&user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrUserExists
+		}
+		return fmt.Errorf("error querying database: %v", err)
+	}
+
+	if err := replaceLanguages(ctx, tx, user.ID, user.NativeLanguages, user.LearningLanguages); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// GetUserByID returns the user's profile, or nil if the user does not exist
+func (r *Repository) GetUserByID(ctx context.Context, userID string) (*User, error) {
+	query := `
+		SELECT id, display_name, COALESCE(timezone, ''), COALESCE(avatar_url, ''), created_at, updated_at
+		FROM users
+		WHERE id = $1`
+
+	var user User
+	err := r.db.QueryRow(ctx, query, userID).Scan(
+		&user.ID,
+		&user.DisplayName,
+		&user.Timezone,
+		&user.AvatarURL,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	user.NativeLanguages, err = r.getNativeLanguages(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.LearningLanguages, err = r.getLearningLanguages(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// UpdateUser applies the non-nil fields of update and returns the updated profile, or nil
+// if the user does not exist
+func (r *Repository) UpdateUser(ctx context.Context, userID string, update UserUpdate) (*User, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE users SET
+			display_name = COALESCE($2, display_name),
+			timezone = CASE WHEN $3::TEXT IS NULL THEN timezone ELSE NULLIF($3, '') END,
+			avatar_url = CASE WHEN $4::TEXT IS NULL THEN avatar_url ELSE NULLIF($4, '') END
+		WHERE id = $1`
+
+	tag, err := tx.Exec(ctx, query, userID, update.DisplayName, update.Timezone, update.AvatarURL)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, nil
+	}
+
+	if update.NativeLanguages != nil || update.LearningLanguages != nil {
+		native := update.NativeLanguages
+		if native == nil {
+			native, err = r.getNativeLanguages(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		learning := update.LearningLanguages
+		if learning == nil {
+			learning, err = r.getLearningLanguages(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if err := replaceLanguages(ctx, tx, userID, native, learning); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return r.GetUserByID(ctx, userID)
+}
+
+func (r *Repository) getNativeLanguages(ctx context.Context, userID string) ([]string, error) {
+	rows, err := r.db.Query(ctx, "SELECT language FROM user_native_languages WHERE user_id = $1 ORDER BY position", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	languages := []string{}
+	for rows.Next() {
+		var language string
+		if err := rows.Scan(&language); err != nil {
+			return nil, err
+		}
+		languages = append(languages, language)
+	}
+
+	return languages, rows.Err()
+}
+
+func (r *Repository) getLearningLanguages(ctx context.Context, userID string) ([]LearningLanguage, error) {
+	rows, err := r.db.Query(ctx, "SELECT language, proficiency FROM user_learning_languages WHERE user_id = $1 ORDER BY position", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	learning := []LearningLanguage{}
+	for rows.Next() {
+		var l LearningLanguage
+		if err := rows.Scan(&l.Language, &l.Proficiency); err != nil {
+			return nil, err
+		}
+		learning = append(learning, l)
+	}
+
+	return learning, rows.Err()
+}
+
+// replaceLanguages replaces the user's language lists, keeping their order
+func replaceLanguages(ctx context.Context, tx pgx.Tx, userID string, native []string, learning []LearningLanguage) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM user_native_languages WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("error querying database: %v", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM user_learning_languages WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("error querying database: %v", err)
+	}
+
+	for i, language := range native {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO user_native_languages (user_id, language, position) VALUES ($1, $2, $3)",
+			userID, language, i,
+		)
+		if err != nil {
+			return fmt.Errorf("error querying database: %v", err)
+		}
+	}
+
+	for i, l := range learning {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO user_learning_languages (user_id, language, proficiency, position) VALUES ($1, $2, $3, $4)",
+			userID, l.Language, l.Proficiency, i,
+		)
+		if err != nil {
+			return fmt.Errorf("error querying database: %v", err)
+		}
+	}
+
+	return nil
+}
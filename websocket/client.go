@@ -0,0 +1,234 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Application close codes telling clients why the server disconnected them. Oversized
+// messages are closed with the standard 1009 (message too big) and shutdown with 1012
+// (service restart).
+const (
+	// ClosePongTimeout closes a connection that answered neither pings nor with any message
+	// within the pong timeout
+	ClosePongTimeout = 4000
+	// CloseTooSlow closes a connection that could not keep up with its messages: its send
+	// buffer filled up or a write timed out
+	CloseTooSlow = 4001
+	// CloseReplaced closes a connection replaced by a newer one of the same user
+	CloseReplaced = 4002
+	// CloseAlreadyConnected refuses a connection because the user is already connected and
+	// duplicates are rejected
+	CloseAlreadyConnected = 4003
+	// CloseSuspended closes the connections of a user who was suspended, giving the reason
+	CloseSuspended = 4004
+)
+
+// maxCloseReasonLength is the longest close reason that fits in a control frame with its code
+const maxCloseReasonLength = 123
+
+type Client struct {
+	ID      string
+	conn    *websocket.Conn
+	send    chan outbound
+	manager *Manager
+	// deviceID identifies the device of the connection, if the client said, so that each
+	// device keeps its own outbox cursor
+	deviceID string
+	// resumeFrom is the sequence number of the last outbox message the client saw, if it said
+	resumeFrom string
+	closeOnce  sync.Once
+}
+
+func newClient(m *Manager, userID, deviceID string, conn *websocket.Conn, resumeFrom string) *Client {
+	client := &Client{
+		ID:         userID,
+		conn:       conn,
+		send:       make(chan outbound, 256),
+		manager:    m,
+		deviceID:   deviceID,
+		resumeFrom: resumeFrom,
+	}
+
+	conn.SetReadLimit(int64(m.config.MaxMessageSize))
+	client.extendReadDeadline()
+	conn.SetPongHandler(func(string) error {
+		client.extendReadDeadline()
+		return nil
+	})
+
+	return client
+}
+
+// extendReadDeadline gives the client another pong timeout to show it is still there
+func (c *Client) extendReadDeadline() {
+	c.conn.SetReadDeadline(time.Now().Add(c.manager.config.PongTimeout))
+}
+
+// closeWith sends a close frame with code and text, unless code is zero, and closes the
+// connection. It may be called from any goroutine; only the first call has an effect, so
+// the reason that ended the connection first is the one the client sees.
+func (c *Client) closeWith(code int, text string, deadline time.Time) {
+	c.closeOnce.Do(func() {
+		if code != 0 {
+			err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), deadline)
+			if err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+				log.Printf("Failed to send close frame to client %s: %v", c.ID, err)
+			}
+		}
+		c.conn.Close()
+	})
+}
+
+func (c *Client) readPump() {
+	defer func() {
+		c.manager.unregister <- c
+	}()
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.closeAfterReadError(err)
+			return
+		}
+		c.extendReadDeadline()
+		c.manager.handleInbound(c, data)
+	}
+}
+
+// closeAfterReadError closes the connection with the close code matching the error that
+// ended the read loop
+func (c *Client) closeAfterReadError(err error) {
+	deadline := time.Now().Add(c.manager.config.WriteTimeout)
+
+	var (
+		closeErr *websocket.CloseError
+		netErr   net.Error
+	)
+	switch {
+	case errors.As(err, &closeErr):
+		// The client closed the connection and was already answered with a close frame
+		c.closeWith(0, "", deadline)
+	case errors.Is(err, websocket.ErrReadLimit):
+		// The connection already sent 1009 (message too big)
+		log.Printf("Client %s sent a message over %d bytes, disconnecting", c.ID, c.manager.config.MaxMessageSize)
+		c.closeWith(0, "", deadline)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		log.Printf("Client %s stopped answering pings, disconnecting", c.ID)
+		c.closeWith(ClosePongTimeout, "pong timeout", deadline)
+	default:
+		c.closeWith(0, "", deadline)
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.manager.config.PingInterval)
+	defer ticker.Stop()
+
+	writeTimeout := c.manager.config.WriteTimeout
+
+	replayedSeq, err := c.replay()
+	if err != nil {
+		c.closeAfterWriteError(err)
+		return
+	}
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				// Removed by the manager, which closes the connection itself
+				return
+			}
+			if message.seq != "" && !seqAfter(message.seq, replayedSeq) {
+				// Stored before the replay read the outbox, so already written
+				continue
+			}
+
+			if err := c.write(message); err != nil {
+				c.closeAfterWriteError(err)
+				return
+			}
+
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				c.closeAfterWriteError(err)
+				return
+			}
+		}
+	}
+}
+
+// replay writes the outbox messages the client missed, before any message sent since it
+// registered, and returns the sequence number of the last one. Failing to read the outbox
+// only skips the replay.
+func (c *Client) replay() (string, error) {
+	outbox := c.manager.outbox
+	if outbox == nil {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.manager.config.WriteTimeout)
+	defer cancel()
+
+	after := c.resumeFrom
+	if after == "" {
+		var err error
+		after, err = outbox.LastAcknowledged(ctx, c.ID, c.deviceID)
+		if err != nil {
+			log.Printf("Failed to read last delivered message of client %s: %v", c.ID, err)
+			return "", nil
+		}
+	}
+
+	entries, err := outbox.ReadAfter(ctx, c.ID, after)
+	if err != nil {
+		log.Printf("Failed to read missed messages of client %s: %v", c.ID, err)
+		return "", nil
+	}
+
+	for _, entry := range entries {
+		if err := c.write(outbound{seq: entry.Seq, data: entry.Message}); err != nil {
+			return "", err
+		}
+	}
+	if len(entries) > 0 {
+		log.Printf("Replayed %d missed messages to client %s", len(entries), c.ID)
+		return entries[len(entries)-1].Seq, nil
+	}
+	return after, nil
+}
+
+// write writes a message to the connection and, if it came from the outbox, records that the
+// client's device received it
+func (c *Client) write(message outbound) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.manager.config.WriteTimeout))
+	if err := c.conn.WriteMessage(websocket.TextMessage, message.data); err != nil {
+		return err
+	}
+
+	if message.seq != "" && c.manager.outbox != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.manager.config.WriteTimeout)
+		defer cancel()
+		if err := c.manager.outbox.Acknowledge(ctx, c.ID, c.deviceID, message.seq); err != nil {
+			log.Printf("Failed to record delivery of message %s to client %s: %v", message.seq, c.ID, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) closeAfterWriteError(err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		log.Printf("Write to client %s timed out, disconnecting", c.ID)
+		c.closeWith(CloseTooSlow, "write timeout", time.Now().Add(c.manager.config.WriteTimeout))
+		return
+	}
+	c.closeWith(0, "", time.Now())
+}
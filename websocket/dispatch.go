@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// maxRequestIDLength bounds the request IDs clients may attach to their messages
+const maxRequestIDLength = 128
+
+// ErrorCode tells a client why one of its messages was refused
+type ErrorCode string
+
+const (
+	ErrorInvalidMessage ErrorCode = "invalid_message" // Not a JSON envelope with a type
+	ErrorUnknownType    ErrorCode = "unknown_type"    // No handler for the message type
+	ErrorInvalidPayload ErrorCode = "invalid_payload" // The data does not fit the message type
+	ErrorForbidden      ErrorCode = "forbidden"       // The user may not do this, e.g. in another user's session
+	ErrorConflict       ErrorCode = "conflict"        // Not possible in the current state, e.g. of the session
+	ErrorInternal       ErrorCode = "internal_error"  // The server failed to handle the message
+)
+
+// ErrorNotification is the payload of error messages, sent in reply to a message that could
+// not be handled
+type ErrorNotification struct {
+	Code        ErrorCode   `json:"code"`
+	Message     string      `json:"message"`
+	MessageType MessageType `json:"message_type,omitempty"`
+}
+
+// HandlerError is returned by handlers to reply to the client with Code and the message of
+// Err. Other errors are replied to with an internal error that hides their message.
+type HandlerError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *HandlerError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// NewHandlerError wraps err so that the client is told about it with code
+func NewHandlerError(code ErrorCode, err error) error {
+	return &HandlerError{Code: code, Err: err}
+}
+
+type inboundContextKey struct{}
+
+// inbound is the message being handled, kept in the handler's context so that replies go to
+// the connection that sent it
+type inbound struct {
+	client    *Client
+	requestID string
+}
+
+// RequestIDFromContext returns the request ID of the message being handled, if the client set one
+func RequestIDFromContext(ctx context.Context) string {
+	if in, ok := ctx.Value(inboundContextKey{}).(*inbound); ok {
+		return in.requestID
+	}
+	return ""
+}
+
+// Reply sends a message to the connection whose message is being handled, carrying its
+// request ID. Other connections of the same user do not receive it.
+func (m *Manager) Reply(ctx context.Context, message Message) error {
+	in, ok := ctx.Value(inboundContextKey{}).(*inbound)
+	if !ok {
+		return errors.New("no inbound message to reply to")
+	}
+
+	message.RequestID = in.requestID
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	m.deliverTo(in.client, outbound{data: data})
+	return nil
+}
+
+// deliverTo queues an encoded message for one connection, if it is still registered
+func (m *Manager) deliverTo(client *Client, out outbound) {
+	m.mutex.RLock()
+	_, registered := m.clients[client.ID][client]
+	overflowed := false
+	if registered {
+		select {
+		case client.send <- out:
+		default:
+			overflowed = true
+		}
+	}
+	m.mutex.RUnlock()
+
+	if overflowed {
+		sendBufferOverflows.Inc()
+		client.closeWith(CloseTooSlow, "send buffer full", time.Now().Add(m.config.WriteTimeout))
+	}
+}
+
+// handleInbound validates the envelope of a message and dispatches it to the handler of its
+// type. Messages that cannot be handled are answered with an error message.
+func (m *Manager) handleInbound(client *Client, data []byte) {
+	var message InboundMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		log.Printf("Invalid message from client %s: %v", client.ID, err)
+		m.replyError(client, "", "", ErrorInvalidMessage, "Messages must be JSON objects with a type")
+		return
+	}
+
+	if len(message.RequestID) > maxRequestIDLength {
+		m.replyError(client, "", message.Type, ErrorInvalidMessage, fmt.Sprintf("request_id is longer than %d characters", maxRequestIDLength))
+		return
+	}
+	if message.Type == "" {
+		m.replyError(client, message.RequestID, "", ErrorInvalidMessage, "Missing message type")
+		return
+	}
+
+	// Handlers decode an omitted payload as an empty one and report the missing fields
+	payload := bytes.TrimSpace(message.Data)
+	if len(payload) == 0 || bytes.Equal(payload, []byte("null")) {
+		payload = []byte("{}")
+	}
+	if payload[0] != '{' {
+		m.replyError(client, message.RequestID, message.Type, ErrorInvalidMessage, "data must be an object")
+		return
+	}
+
+	m.mutex.RLock()
+	handler, exists := m.handlers[message.Type]
+	m.mutex.RUnlock()
+
+	if !exists {
+		log.Printf("No handler for message type '%s' from client %s", message.Type, client.ID)
+		m.replyError(client, message.RequestID, message.Type, ErrorUnknownType, fmt.Sprintf("Unknown message type '%s'", message.Type))
+		return
+	}
+
+	// Handlers run on the read loop, so they are bounded to leave time for reading pongs
+	ctx, cancel := context.WithTimeout(context.Background(), m.config.HandlerTimeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, inboundContextKey{}, &inbound{client: client, requestID: message.RequestID})
+	if err := handler(ctx, client.ID, payload); err != nil {
+		log.Printf("Error handling '%s' message from client %s: %v", message.Type, client.ID, err)
+
+		var handlerErr *HandlerError
+		if errors.As(err, &handlerErr) {
+			m.replyError(client, message.RequestID, message.Type, handlerErr.Code, handlerErr.Error())
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			m.replyError(client, message.RequestID, message.Type, ErrorInternal, "Timed out handling message")
+			return
+		}
+		m.replyError(client, message.RequestID, message.Type, ErrorInternal, "Failed to handle message")
+	}
+}
+
+func (m *Manager) replyError(client *Client, requestID string, messageType MessageType, code ErrorCode, text string) {
+	data, err := json.Marshal(Message{
+		Type:      ErrorMessage,
+		RequestID: requestID,
+		Data: ErrorNotification{
+			Code:        code,
+			Message:     text,
+			MessageType: messageType,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to encode error reply to client %s: %v", client.ID, err)
+		return
+	}
+
+	m.deliverTo(client, outbound{data: data})
+}
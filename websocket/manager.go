@@ -1,10 +1,18 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"langapp-backend/auth"
+	"langapp-backend/config"
 
 	"github.com/gorilla/websocket"
 )
@@ -13,110 +21,333 @@ type MessageType string
 
 const (
 	// Outgoing message types (server to client)
-	MatchFound           MessageType = "match_found"           // Users matched, not yet connected
+	MatchProposed        MessageType = "match_proposed"        // Users matched, both must accept
+	MatchFound           MessageType = "match_found"           // Both users accepted, not yet connected
+	MatchFailed          MessageType = "match_failed"          // A proposed match was declined or not accepted in time
 	MatchmakingCancelled MessageType = "matchmaking_cancelled" // Matchmaking was cancelled due to timeout or other issue
 	StillSearching       MessageType = "still_searching"       // Heartbeat notification that matchmaking still ongoing
 	ConnectionInitiated  MessageType = "connection_initiated"  // WebRTC connection initiation started
 	SignalingMessage     MessageType = "signaling_message"     // WebRTC signaling message (offer/answer/ICE)
 	CallActive           MessageType = "call_active"           // Audio call is now active
 	ConnectionFailed     MessageType = "connection_failed"     // WebRTC connection failed
-	
+	CallEnded            MessageType = "call_ended"            // Audio call has ended
+	QueueStatusUpdate    MessageType = "queue_status"          // Reply to a queue status request
+	ErrorMessage         MessageType = "error"                 // Reply to a message that could not be handled
+
 	// Incoming message types (client to server)
-	SignalingOffer       MessageType = "signaling_offer"       // WebRTC offer from client
-	SignalingAnswer      MessageType = "signaling_answer"      // WebRTC answer from client
-	SignalingICE         MessageType = "signaling_ice"         // ICE candidate from client
-	InitiateConnection   MessageType = "initiate_connection"   // Client wants to start WebRTC connection
-	ConnectionSuccess    MessageType = "connection_success"    // Client reports successful connection
-	ConnectionFailure    MessageType = "connection_failure"    // Client reports connection failure
+	SignalingOffer     MessageType = "signaling_offer"      // WebRTC offer from client
+	SignalingAnswer    MessageType = "signaling_answer"     // WebRTC answer from client
+	SignalingICE       MessageType = "signaling_ice"        // ICE candidate from client
+	InitiateConnection MessageType = "initiate_connection"  // Client wants to start WebRTC connection
+	ConnectionSuccess  MessageType = "connection_success"   // Client reports successful connection
+	ConnectionFailure  MessageType = "connection_failure"   // Client reports connection failure
+	EndCall            MessageType = "end_call"             // Client hangs up the call
+	QueueStatusRequest MessageType = "queue_status_request" // Client asks whether and where it is queued
+	CancelQueue        MessageType = "cancel_matchmaking"   // Client leaves the matchmaking queue
+	AcceptMatch        MessageType = "accept_match"         // Client accepts a proposed match
+	DeclineMatch       MessageType = "decline_match"        // Client declines a proposed match
 )
 
-type Manager struct {
-	clients    map[string]*Client
-	register   chan *Client
-	unregister chan *Client
-	mutex      sync.RWMutex
+// SuspensionChecker explains why a user is suspended, or returns an empty string if they are not
+type SuspensionChecker interface {
+	SuspensionReason(ctx context.Context, userID string) (string, error)
 }
 
-type Client struct {
-	ID      string
-	conn    *websocket.Conn
-	send    chan []byte
-	manager *Manager
+// Policies for a user who opens a connection while already connected to this instance
+const (
+	DuplicateReplace = "replace" // Close the old connection with CloseReplaced
+	DuplicateReject  = "reject"  // Refuse the new connection
+	DuplicateMulti   = "multi"   // Keep both and deliver every message to each
+)
+
+type Manager struct {
+	// clients holds the connections of each user connected to this instance. Only the Start
+	// goroutine modifies it, under the write lock; other goroutines read it under the read lock.
+	clients     map[string]map[*Client]struct{}
+	register    chan registration
+	unregister  chan *Client
+	handlers    map[MessageType]HandlerFunc
+	nodeID      string
+	relay       Relay
+	outbox      *Outbox
+	suspensions SuspensionChecker
+	config      config.WebSocketConfig
+	upgrader    websocket.Upgrader
+	closing     atomic.Bool
+	mutex       sync.RWMutex
 }
 
+// Message is a message sent to a client. Replies carry the request ID of the message they
+// answer; messages kept in the outbox carry their sequence number.
 type Message struct {
-	Type MessageType `json:"type"`
-	Data interface{} `json:"data"`
+	Type      MessageType `json:"type"`
+	RequestID string      `json:"request_id,omitempty"`
+	Seq       string      `json:"seq,omitempty"`
+	Data      interface{} `json:"data"`
+}
+
+// outbound is an encoded message queued for a connection, with its outbox sequence number if any
+type outbound struct {
+	seq  string
+	data []byte
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // TODO - implement this function
-	},
+// InboundMessage is a message received from a client. Data is left raw so that
+// each handler can decode its own payload. The optional RequestID is echoed in replies.
+type InboundMessage struct {
+	Type      MessageType     `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	Data      json.RawMessage `json:"data"`
 }
 
-func NewManager() *Manager {
+// registration asks the Start goroutine to add a client and answers whether it was accepted
+type registration struct {
+	client   *Client
+	accepted chan bool
+}
+
+// HandlerFunc handles an inbound message of a registered type sent by userID
+type HandlerFunc func(ctx context.Context, userID string, data json.RawMessage) error
+
+// NewManager creates a manager for the clients connected to this instance, identified by
+// nodeID. A nil relay limits delivery to local clients, and a nil outbox drops the messages
+// of offline users. Suspended users are refused a connection. Browsers may only connect from
+// the configured origins, where "*" allows any origin.
+func NewManager(nodeID string, relay Relay, outbox *Outbox, suspensions SuspensionChecker, cfg config.WebSocketConfig) *Manager {
 	return &Manager{
-		clients:    make(map[string]*Client),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		clients:     make(map[string]map[*Client]struct{}),
+		register:    make(chan registration),
+		unregister:  make(chan *Client),
+		handlers:    make(map[MessageType]HandlerFunc),
+		nodeID:      nodeID,
+		relay:       relay,
+		outbox:      outbox,
+		suspensions: suspensions,
+		config:      cfg,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: checkOrigin(cfg.AllowedOrigins),
+		},
 	}
 }
 
+func checkOrigin(allowedOrigins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Not a browser, so not subject to cross-site request forgery
+			return true
+		}
+
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RegisterHandler registers the handler invoked for inbound messages of the given type
+func (m *Manager) RegisterHandler(messageType MessageType, handler HandlerFunc) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.handlers[messageType] = handler
+}
+
+// Start owns the clients map: it adds and removes every connection, so that a connection is
+// only ever removed once and its send channel only ever closed once
 func (m *Manager) Start() {
 	for {
 		select {
-		case client := <-m.register:
-			m.mutex.Lock()
-			m.clients[client.ID] = client
-			m.mutex.Unlock()
-			log.Printf("Client %s connected", client.ID)
+		case reg := <-m.register:
+			reg.accepted <- m.addClient(reg.client)
 
 		case client := <-m.unregister:
-			m.mutex.Lock()
-			if _, exists := m.clients[client.ID]; exists {
-				delete(m.clients, client.ID)
-				close(client.send)
-				log.Printf("Client %s disconnected", client.ID)
-			}
-			m.mutex.Unlock()
+			m.removeClient(client)
+		}
+	}
+}
+
+// addClient adds a connection according to the duplicate connection policy and reports
+// whether it was accepted
+func (m *Manager) addClient(client *Client) bool {
+	m.mutex.Lock()
+	connections := m.clients[client.ID]
+	if len(connections) > 0 && m.config.DuplicatePolicy == DuplicateReject {
+		m.mutex.Unlock()
+		return false
+	}
+
+	var replaced []*Client
+	if m.config.DuplicatePolicy == DuplicateReplace {
+		for old := range connections {
+			delete(connections, old)
+			close(old.send)
+			replaced = append(replaced, old)
+		}
+	}
+	if connections == nil {
+		connections = make(map[*Client]struct{})
+		m.clients[client.ID] = connections
+	}
+	connections[client] = struct{}{}
+	count := len(connections)
+	m.mutex.Unlock()
+
+	// The replaced connections end their read loops, whose unregistration is then ignored. The
+	// close frames are written off this goroutine, so that a dead peer cannot stall every other
+	// registration until the write deadline.
+	deadline := time.Now().Add(m.config.WriteTimeout)
+	for _, old := range replaced {
+		go old.closeWith(CloseReplaced, "connected from another device", deadline)
+	}
+
+	m.setPresence(client.ID)
+	log.Printf("Client %s connected (%d connections)", client.ID, count)
+	return true
+}
+
+// removeClient removes a connection that is still registered, clearing the user's presence
+// when it was their last one
+func (m *Manager) removeClient(client *Client) {
+	m.mutex.Lock()
+	connections := m.clients[client.ID]
+	_, exists := connections[client]
+	if exists {
+		delete(connections, client)
+		close(client.send)
+		if len(connections) == 0 {
+			delete(m.clients, client.ID)
 		}
 	}
+	lastConnection := exists && len(connections) == 0
+	m.mutex.Unlock()
+
+	if !exists {
+		return
+	}
+	log.Printf("Client %s disconnected", client.ID)
+	if lastConnection {
+		m.clearPresence(client.ID)
+	}
 }
 
+// isConnected reports whether the user has a connection to this instance
+func (m *Manager) isConnected(userID string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.clients[userID]) > 0
+}
+
+// maxDeviceIDLength bounds the device IDs clients may connect with
+const maxDeviceIDLength = 128
+
+// HandleWebSocket upgrades an authenticated request to a WebSocket connection for the
+// verified user. The client first receives the outbox messages following the last_seq query
+// parameter, or following the last message written to the device named by device_id if it is
+// omitted.
 func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
+	userID := auth.UserIDFromContext(r.Context())
 	if userID == "" {
-		http.Error(w, "Missing user_id parameter", http.StatusBadRequest)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	lastSeq := r.URL.Query().Get("last_seq")
+	if lastSeq != "" {
+		if _, _, ok := parseSeq(lastSeq); !ok {
+			http.Error(w, "Invalid last_seq", http.StatusBadRequest)
+			return
+		}
+	}
+
+	deviceID := r.URL.Query().Get("device_id")
+	if len(deviceID) > maxDeviceIDLength {
+		http.Error(w, fmt.Sprintf("device_id is longer than %d characters", maxDeviceIDLength), http.StatusBadRequest)
+		return
+	}
+
+	if m.closing.Load() {
+		http.Error(w, "Server is restarting, please reconnect", http.StatusServiceUnavailable)
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	reason, err := m.suspensions.SuspensionReason(r.Context(), userID)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		log.Printf("Failed to check suspension of user %s: %v", userID, err)
+		http.Error(w, "Failed to connect", http.StatusInternalServerError)
+		return
+	}
+	if reason != "" {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+
+	if m.config.DuplicatePolicy == DuplicateReject && m.isConnected(userID) {
+		http.Error(w, "Already connected", http.StatusConflict)
 		return
 	}
 
-	client := &Client{
-		ID:      userID,
-		conn:    conn,
-		send:    make(chan []byte, 256),
-		manager: m,
+	conn, err := m.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
 	}
 
-	m.register <- client
+	client := newClient(m, userID, deviceID, conn, lastSeq)
+
+	// Another connection may have been registered since the check above
+	accepted := make(chan bool, 1)
+	m.register <- registration{client: client, accepted: accepted}
+	if !<-accepted {
+		client.closeWith(CloseAlreadyConnected, "already connected", time.Now().Add(m.config.WriteTimeout))
+		return
+	}
 
 	go client.writePump()
 	go client.readPump()
 }
 
-func (m *Manager) SendMessage(userID string, message Message) error {
+// Shutdown refuses new connections and closes every connected client with a close frame
+// carrying reason, so clients know to reconnect to another instance
+func (m *Manager) Shutdown(ctx context.Context, reason string) {
+	m.closing.Store(true)
+
 	m.mutex.RLock()
-	client, exists := m.clients[userID]
+	clients := make([]*Client, 0, len(m.clients))
+	for _, connections := range m.clients {
+		for client := range connections {
+			clients = append(clients, client)
+		}
+	}
 	m.mutex.RUnlock()
 
-	if !exists {
-		return nil
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Second)
+	}
+
+	for _, client := range clients {
+		client.closeWith(websocket.CloseServiceRestart, reason, deadline)
+	}
+
+	log.Printf("Closed %d WebSocket connections: %s", len(clients), reason)
+}
+
+// SendMessage delivers a message to a user, relaying it to the instance they are connected
+// to if it is not this one. Durable messages are first stored in the outbox, so that a user who
+// is offline or loses the connection receives them when they connect.
+func (m *Manager) SendMessage(userID string, message Message) error {
+	ctx := context.Background()
+
+	message.Seq = ""
+	if m.outbox != nil && durableTypes[message.Type] {
+		seq, err := m.outbox.Append(ctx, userID, message)
+		if err != nil {
+			// Still deliver the message to a connected user
+			log.Printf("Failed to store '%s' message for user %s: %v", message.Type, userID, err)
+		}
+		message.Seq = seq
 	}
 
 	data, err := json.Marshal(message)
@@ -124,36 +355,37 @@ func (m *Manager) SendMessage(userID string, message Message) error {
 		return err
 	}
 
-	select {
-	case client.send <- data:
-	default:
-		close(client.send)
-		delete(m.clients, userID)
+	out := outbound{seq: message.Seq, data: data}
+	if m.deliver(userID, out) {
+		return nil
 	}
 
-	return nil
+	return m.relayMessage(ctx, userID, out)
 }
 
-func (c *Client) readPump() {
-	defer func() {
-		c.manager.unregister <- c
-		c.conn.Close()
-	}()
+// deliver queues an encoded message for every local connection of the user and reports
+// whether the user is connected to this instance. Connections whose send buffer is full are
+// closed with CloseTooSlow; their read loop then unregisters them.
+func (m *Manager) deliver(userID string, out outbound) bool {
+	var overflowed []*Client
 
-	for {
-		_, _, err := c.conn.ReadMessage()
-		if err != nil {
-			break
+	// Sending under the read lock guarantees that Start has not closed the channel
+	m.mutex.RLock()
+	connections := m.clients[userID]
+	connected := len(connections) > 0
+	for client := range connections {
+		select {
+		case client.send <- out:
+		default:
+			overflowed = append(overflowed, client)
 		}
 	}
-}
-
-func (c *Client) writePump() {
-	defer c.conn.Close()
+	m.mutex.RUnlock()
 
-	for message := range c.send {
-		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			return
-		}
+	for _, client := range overflowed {
+		sendBufferOverflows.Inc()
+		client.closeWith(CloseTooSlow, "send buffer full", time.Now().Add(m.config.WriteTimeout))
 	}
+
+	return connected
 }
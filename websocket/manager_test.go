@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin header", []string{"https://app.example.com"}, "", true},
+		{"wildcard", []string{"*"}, "https://evil.example.com", true},
+		{"listed origin", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"case-insensitive", []string{"https://app.example.com"}, "https://APP.example.com", true},
+		{"one of several", []string{"https://a.example.com", "https://b.example.com"}, "https://b.example.com", true},
+		{"unlisted origin", []string{"https://app.example.com"}, "https://evil.example.com", false},
+		{"different scheme", []string{"https://app.example.com"}, "http://app.example.com", false},
+		{"nothing allowed", nil, "https://app.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := checkOrigin(tt.allowed)(r); got != tt.want {
+				t.Fatalf("checkOrigin(%v)(%q) = %v, want %v", tt.allowed, tt.origin, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package websocket
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var sendBufferOverflows = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "langapp",
+	Subsystem: "websocket",
+	Name:      "send_buffer_overflows_total",
+	Help:      "Messages dropped because a client's send buffer was full; the client is disconnected.",
+})
+
+// ClientsCollector reports the number of connections to this instance
+func (m *Manager) ClientsCollector() prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "langapp",
+		Subsystem: "websocket",
+		Name:      "connected_clients",
+		Help:      "Client connections to this instance; a user may have several.",
+	}, func() float64 {
+		m.mutex.RLock()
+		defer m.mutex.RUnlock()
+		count := 0
+		for _, connections := range m.clients {
+			count += len(connections)
+		}
+		return float64(count)
+	})
+}
@@ -0,0 +1,187 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OutboxClient is the subset of the Redis client used by the outbox
+type OutboxClient interface {
+	Pipeline() redis.Pipeliner
+	XRangeN(ctx context.Context, stream, start, stop string, count int64) *redis.XMessageSliceCmd
+	Get(ctx context.Context, key string) *redis.StringCmd
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+}
+
+// Outbox keeps the recent messages sent to each user in a Redis stream, so that a client that
+// was offline or lost its connection gets the messages it missed when it connects. The stream
+// entry IDs are the sequence numbers of the messages. Each stream keeps at most maxLength
+// messages no older than ttl.
+type Outbox struct {
+	client    OutboxClient
+	ttl       time.Duration
+	maxLength int64
+}
+
+// durableTypes are the notifications kept in the outbox: those that change what the client
+// must do next. Heartbeats and signaling messages are only useful while they are fresh, so
+// they are delivered without being stored.
+var durableTypes = map[MessageType]bool{
+	MatchProposed:        true,
+	MatchFound:           true,
+	MatchFailed:          true,
+	MatchmakingCancelled: true,
+	ConnectionInitiated:  true,
+	CallActive:           true,
+	ConnectionFailed:     true,
+	CallEnded:            true,
+}
+
+// OutboxEntry is a stored message with its sequence number
+type OutboxEntry struct {
+	Seq     string
+	Message []byte
+}
+
+// storedMessage is a Message decoded from the outbox, its data kept as stored
+type storedMessage struct {
+	Type MessageType     `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+func NewOutbox(client OutboxClient, ttl time.Duration, maxLength int) *Outbox {
+	return &Outbox{
+		client:    client,
+		ttl:       ttl,
+		maxLength: int64(maxLength),
+	}
+}
+
+// Append stores a message for the user and returns its sequence number
+func (o *Outbox) Append(ctx context.Context, userID string, message Message) (string, error) {
+	payload, err := json.Marshal(message.Data)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(storedMessage{Type: message.Type, Data: payload})
+	if err != nil {
+		return "", err
+	}
+
+	key := outboxKey(userID)
+	minID := strconv.FormatInt(time.Now().Add(-o.ttl).UnixMilli(), 10)
+
+	pipe := o.client.Pipeline()
+	seqCmd := pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: o.maxLength,
+		Approx: true,
+		Values: map[string]interface{}{"message": data},
+	})
+	pipe.XTrimMinID(ctx, key, minID)
+	pipe.Expire(ctx, key, o.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", fmt.Errorf("failed to store message for user '%s': %w", userID, err)
+	}
+
+	return seqCmd.Val(), nil
+}
+
+// ReadAfter returns the stored messages of the user that follow seq, oldest first, encoded
+// with their sequence numbers. An empty seq reads every stored message.
+func (o *Outbox) ReadAfter(ctx context.Context, userID, seq string) ([]OutboxEntry, error) {
+	start := "-"
+	if seq != "" {
+		start = "(" + seq
+	}
+
+	streamEntries, err := o.client.XRangeN(ctx, outboxKey(userID), start, "+", o.maxLength).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read messages of user '%s': %w", userID, err)
+	}
+
+	entries := make([]OutboxEntry, 0, len(streamEntries))
+	for _, streamEntry := range streamEntries {
+		value, _ := streamEntry.Values["message"].(string)
+
+		var stored storedMessage
+		if err := json.Unmarshal([]byte(value), &stored); err != nil {
+			return nil, fmt.Errorf("failed to decode message %s of user '%s': %w", streamEntry.ID, userID, err)
+		}
+
+		data, err := json.Marshal(Message{Type: stored.Type, Seq: streamEntry.ID, Data: stored.Data})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, OutboxEntry{Seq: streamEntry.ID, Message: data})
+	}
+
+	return entries, nil
+}
+
+// Acknowledge records seq as the last message written to the connections of the user's device.
+// Each device keeps its own cursor, so that one device reading a message does not skip it on
+// another; connections without a device ID share one.
+func (o *Outbox) Acknowledge(ctx context.Context, userID, deviceID, seq string) error {
+	return o.client.Set(ctx, outboxAckKey(userID, deviceID), seq, o.ttl).Err()
+}
+
+// LastAcknowledged returns the sequence number of the last message written to the user's
+// device, or an empty string if none was written within the retention
+func (o *Outbox) LastAcknowledged(ctx context.Context, userID, deviceID string) (string, error) {
+	seq, err := o.client.Get(ctx, outboxAckKey(userID, deviceID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return seq, err
+}
+
+func outboxKey(userID string) string {
+	return fmt.Sprintf("websocket:outbox:%s", userID)
+}
+
+func outboxAckKey(userID, deviceID string) string {
+	if deviceID == "" {
+		return fmt.Sprintf("websocket:outbox:%s:ack", userID)
+	}
+	return fmt.Sprintf("websocket:outbox:%s:ack:%s", userID, deviceID)
+}
+
+// parseSeq splits a sequence number, a Redis stream ID such as "1700000000000-0", into its
+// millisecond time and counter
+func parseSeq(seq string) (uint64, uint64, bool) {
+	ms, counter, found := strings.Cut(seq, "-")
+	if !found {
+		return 0, 0, false
+	}
+	msValue, err := strconv.ParseUint(ms, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	counterValue, err := strconv.ParseUint(counter, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return msValue, counterValue, true
+}
+
+// seqAfter reports whether sequence number a follows b. Every sequence number follows the
+// empty one.
+func seqAfter(a, b string) bool {
+	if b == "" {
+		return true
+	}
+	aMS, aCounter, _ := parseSeq(a)
+	bMS, bCounter, _ := parseSeq(b)
+	if aMS != bMS {
+		return aMS > bMS
+	}
+	return aCounter > bCounter
+}
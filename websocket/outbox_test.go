@@ -0,0 +1,27 @@
+package websocket
+
+import "testing"
+
+func TestSeqAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{"after empty", "1700000000000-0", "", true},
+		{"later millisecond", "1700000000001-0", "1700000000000-5", true},
+		{"earlier millisecond", "1700000000000-5", "1700000000001-0", false},
+		{"later counter", "1700000000000-2", "1700000000000-1", true},
+		{"equal", "1700000000000-1", "1700000000000-1", false},
+		{"numeric rather than lexical order", "1700000000000-10", "1700000000000-9", true},
+		{"longer millisecond", "10000000000000-0", "9999999999999-0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := seqAfter(tt.a, tt.b); got != tt.want {
+				t.Fatalf("seqAfter(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
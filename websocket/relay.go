@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	presenceTTL             = 60 * time.Second
+	presenceRefreshInterval = presenceTTL / 3
+)
+
+// Relay lets managers on different instances deliver messages to each other's clients.
+// Each instance subscribes to a channel for its node ID and records which node every
+// connected user is attached to.
+type Relay interface {
+	PublishToNode(ctx context.Context, nodeID string, message interface{}) error
+	SubscribeToNode(ctx context.Context, nodeID string) *redis.PubSub
+	SetPresence(ctx context.Context, userID, nodeID string, ttl time.Duration) error
+	GetPresence(ctx context.Context, userID string) (string, error)
+	ClearPresence(ctx context.Context, userID, nodeID string) error
+}
+
+// relayEnvelope wraps an encoded Message published to another node, or, with a close code,
+// asks that node to disconnect the user
+type relayEnvelope struct {
+	UserID      string          `json:"user_id"`
+	Seq         string          `json:"seq,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	CloseCode   int             `json:"close_code,omitempty"`
+	CloseReason string          `json:"close_reason,omitempty"`
+}
+
+// StartRelay receives messages published to this node for its local clients and keeps
+// their presence records alive. It returns when ctx is cancelled.
+func (m *Manager) StartRelay(ctx context.Context) {
+	if m.relay == nil {
+		return
+	}
+
+	pubsub := m.relay.SubscribeToNode(ctx, m.nodeID)
+	defer pubsub.Close()
+
+	ticker := time.NewTicker(presenceRefreshInterval)
+	defer ticker.Stop()
+
+	log.Printf("WebSocket relay listening for node %s", m.nodeID)
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			var envelope relayEnvelope
+			if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
+				log.Printf("Error unmarshaling relayed message: %v", err)
+				continue
+			}
+
+			if envelope.CloseCode != 0 {
+				m.disconnectLocal(envelope.UserID, envelope.CloseCode, envelope.CloseReason)
+				continue
+			}
+
+			if !m.deliver(envelope.UserID, outbound{seq: envelope.Seq, data: envelope.Payload}) {
+				log.Printf("Relayed message for %s not delivered, client not connected to node %s", envelope.UserID, m.nodeID)
+			}
+
+		case <-ticker.C:
+			m.refreshPresence(ctx)
+		}
+	}
+}
+
+// relayMessage publishes an encoded message to the node the user is connected to, if any
+func (m *Manager) relayMessage(ctx context.Context, userID string, out outbound) error {
+	if m.relay == nil {
+		return nil
+	}
+
+	nodeID, err := m.relay.GetPresence(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if nodeID == "" || nodeID == m.nodeID {
+		// The user is offline, or their presence on this node is stale
+		return nil
+	}
+
+	envelope, err := json.Marshal(relayEnvelope{UserID: userID, Seq: out.seq, Payload: out.data})
+	if err != nil {
+		return err
+	}
+
+	return m.relay.PublishToNode(ctx, nodeID, envelope)
+}
+
+// Disconnect closes every connection of the user with code and reason, on this instance and on
+// the instance the user is connected to, if it is another one
+func (m *Manager) Disconnect(ctx context.Context, userID string, code int, reason string) error {
+	reason = truncateCloseReason(reason)
+	m.disconnectLocal(userID, code, reason)
+
+	if m.relay == nil {
+		return nil
+	}
+	nodeID, err := m.relay.GetPresence(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if nodeID == "" || nodeID == m.nodeID {
+		return nil
+	}
+
+	envelope, err := json.Marshal(relayEnvelope{UserID: userID, CloseCode: code, CloseReason: reason})
+	if err != nil {
+		return err
+	}
+	return m.relay.PublishToNode(ctx, nodeID, envelope)
+}
+
+// disconnectLocal closes the user's connections to this instance. Their read loops then
+// unregister them.
+func (m *Manager) disconnectLocal(userID string, code int, reason string) {
+	m.mutex.RLock()
+	clients := make([]*Client, 0, len(m.clients[userID]))
+	for client := range m.clients[userID] {
+		clients = append(clients, client)
+	}
+	m.mutex.RUnlock()
+
+	deadline := time.Now().Add(m.config.WriteTimeout)
+	for _, client := range clients {
+		go client.closeWith(code, reason, deadline)
+	}
+	if len(clients) > 0 {
+		log.Printf("Disconnecting %d connections of user %s: %s", len(clients), userID, reason)
+	}
+}
+
+// truncateCloseReason shortens a close reason to fit in a control frame, without splitting a
+// UTF-8 character
+func truncateCloseReason(reason string) string {
+	if len(reason) <= maxCloseReasonLength {
+		return reason
+	}
+	end := maxCloseReasonLength
+	for end > 0 && !utf8.RuneStart(reason[end]) {
+		end--
+	}
+	return reason[:end]
+}
+
+func (m *Manager) setPresence(userID string) {
+	if m.relay == nil {
+		return
+	}
+	if err := m.relay.SetPresence(context.Background(), userID, m.nodeID, presenceTTL); err != nil {
+		log.Printf("Failed to record presence for client %s: %v", userID, err)
+	}
+}
+
+func (m *Manager) clearPresence(userID string) {
+	if m.relay == nil {
+		return
+	}
+	if err := m.relay.ClearPresence(context.Background(), userID, m.nodeID); err != nil {
+		log.Printf("Failed to clear presence for client %s: %v", userID, err)
+	}
+}
+
+func (m *Manager) refreshPresence(ctx context.Context) {
+	m.mutex.RLock()
+	userIDs := make([]string, 0, len(m.clients))
+	for userID := range m.clients {
+		userIDs = append(userIDs, userID)
+	}
+	m.mutex.RUnlock()
+
+	for _, userID := range userIDs {
+		if err := m.relay.SetPresence(ctx, userID, m.nodeID, presenceTTL); err != nil {
+			log.Printf("Failed to refresh presence for client %s: %v", userID, err)
+		}
+	}
+}